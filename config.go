@@ -0,0 +1,277 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	"unicode"
+)
+
+const (
+	envPrefix          = "FORWARDER_"
+	configPollInterval = 5 * time.Second
+)
+
+// config holds the settings of a config file by section and flag name, as flag values
+type config map[string]map[string]string
+
+var (
+	configFile string
+	// loadedConfig is the config file currently applied, configOverrides the flags given on the command line or in the
+	// environment which take precedence over it
+	loadedConfig    config
+	configOverrides map[string]bool
+
+	// configSections lists the flags which can be set in each section of the config file
+	configSections = map[string][]string{
+		"inputs": {"inputs", "inputBuffer", "framing", "maxMessageBytes", "truncatedField", "syslogUDP", "syslogTCP",
+			"syslogSourcetype", "hecListen", "hecTokens", "hecQueue", "files", "journalCommand", "journalFile", "journalUnits",
+			"journalPriority", "kubernetesLogs", "kubernetesMetadata", "socketPath", "socketType", "socketMode", "fifoPath",
+			"journald", "sourcetypeField", "stripJournaldFields", "embeddedField", "embeddedFormats", "embeddedCollision",
+			"timestamps", "timezone"},
+		"stages": {"dedup", "dedupField", "dedupWindow", "dedupSize", "stateDir", "multilineStart", "multilineContinue",
+			"multilineField", "multilineKey", "multilineMaxLines", "multilineMaxBytes", "multilineTimeout", "enrich",
+			"enrichHostFacts", "metadataProvider", "metadataFile", "lookupTables", "filterRules", "filterShadow", "sampleRules",
+			"rateLimitField", "rateLimit", "rateLimitBurst", "rateLimitAction", "rateLimitSampleRate", "rateLimitSummary",
+			"redact", "redactRules", "redactKey"},
+		"outputs": {"pipelines", "outputType", "url", "token", "hostname", "workers", "buffer", "batchsize", "batchtimer",
+			"dryrun", "hecMode", "channel", "rawSourcetype", "rawIndex", "indexTemplate", "routes", "fields", "liftFields",
+			"maxFieldLength", "metricFormats", "metricField", "metricsIndex", "metricDimensions"},
+		"retry":   {"bucketName", "awsRegion"},
+		"metrics": {"graphiteserver", "env"},
+	}
+	// reloadableSections can change without a restart, the other sections are only read on start
+	reloadableSections = []string{"stages"}
+)
+
+// loadConfig reads a json config file and checks its settings against the flags they set
+func loadConfig(path string, fs *flag.FlagSet) (config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	d := json.NewDecoder(f)
+	d.UseNumber()
+	sections := map[string]map[string]interface{}{}
+	if err := d.Decode(&sections); err != nil {
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+	c := config{}
+	for section, settings := range sections {
+		names, found := configSections[section]
+		if !found {
+			return nil, fmt.Errorf("%v: unknown section %q, expected one of %v", path, section, strings.Join(sectionNames(), ", "))
+		}
+		c[section] = map[string]string{}
+		for name, v := range settings {
+			if !contains(names, name) {
+				return nil, fmt.Errorf("%v: %v.%v: unknown setting", path, section, name)
+			}
+			value, err := configValue(fs.Lookup(name), v)
+			if err != nil {
+				return nil, fmt.Errorf("%v: %v.%v: %v", path, section, name, err)
+			}
+			c[section][name] = value
+		}
+	}
+	return c, nil
+}
+
+// configValue checks a json value against the type of a flag and returns it in the form accepted by the flag
+func configValue(f *flag.Flag, v interface{}) (string, error) {
+	getter, ok := f.Value.(flag.Getter)
+	if !ok {
+		return fmt.Sprint(v), nil
+	}
+	switch getter.Get().(type) {
+	case bool:
+		if b, ok := v.(bool); ok {
+			return strconv.FormatBool(b), nil
+		}
+		return "", fmt.Errorf("expected true or false, got %v", jsonValue(v))
+	case int:
+		if n, ok := v.(json.Number); ok {
+			if _, err := n.Int64(); err == nil {
+				return n.String(), nil
+			}
+		}
+		return "", fmt.Errorf("expected an integer, got %v", jsonValue(v))
+	case float64:
+		if n, ok := v.(json.Number); ok {
+			return n.String(), nil
+		}
+		return "", fmt.Errorf("expected a number, got %v", jsonValue(v))
+	case string:
+		if s, ok := v.(string); ok {
+			return s, nil
+		}
+		return "", fmt.Errorf("expected a string, got %v", jsonValue(v))
+	}
+	return fmt.Sprint(v), nil
+}
+
+func jsonValue(v interface{}) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(data)
+}
+
+// applyConfig sets the flags of the sections from the config, except the overridden ones. Settings removed since the
+// previous config get their default value back.
+func applyConfig(fs *flag.FlagSet, c, previous config, sections []string, overrides map[string]bool) error {
+	for _, section := range sections {
+		for name := range previous[section] {
+			if _, found := c[section][name]; !found && !overrides[name] {
+				if err := fs.Set(name, fs.Lookup(name).DefValue); err != nil {
+					return fmt.Errorf("%v.%v: %v", section, name, err)
+				}
+			}
+		}
+		for name, value := range c[section] {
+			if overrides[name] {
+				continue
+			}
+			if err := fs.Set(name, value); err != nil {
+				return fmt.Errorf("%v.%v: %v", section, name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// applyEnv sets the flags not given on the command line from environment variables, e.g. FORWARDER_BATCHSIZE for
+// -batchsize or FORWARDER_BUCKET_NAME for -bucketName, and adds them to the overrides
+func applyEnv(fs *flag.FlagSet, getenv func(string) string, overrides map[string]bool) error {
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if err != nil || overrides[f.Name] {
+			return
+		}
+		name := envName(f.Name)
+		if value := getenv(name); value != "" {
+			if setErr := fs.Set(f.Name, value); setErr != nil {
+				err = fmt.Errorf("%v: %v", name, setErr)
+				return
+			}
+			overrides[f.Name] = true
+		}
+	})
+	return err
+}
+
+// envName turns a flag name into the name of the environment variable setting it
+func envName(flagName string) string {
+	name := []rune(envPrefix)
+	for i, r := range flagName {
+		if unicode.IsUpper(r) && i > 0 {
+			name = append(name, '_')
+		}
+		name = append(name, unicode.ToUpper(r))
+	}
+	return string(name)
+}
+
+// setupConfig applies the environment variables and the config file to the flags not given on the command line
+func setupConfig() error {
+	configOverrides = map[string]bool{}
+	flag.Visit(func(f *flag.Flag) {
+		configOverrides[f.Name] = true
+	})
+	if err := applyEnv(flag.CommandLine, os.Getenv, configOverrides); err != nil {
+		return err
+	}
+	if configFile == "" {
+		return nil
+	}
+	c, err := loadConfig(configFile, flag.CommandLine)
+	if err != nil {
+		return err
+	}
+	if err := applyConfig(flag.CommandLine, c, nil, sectionNames(), configOverrides); err != nil {
+		return fmt.Errorf("%v: %v", configFile, err)
+	}
+	loadedConfig = c
+	return nil
+}
+
+// reloadConfig re-reads the config file and applies its reloadable sections. Changes to the other sections are logged
+// as requiring a restart.
+func reloadConfig() error {
+	c, err := loadConfig(configFile, flag.CommandLine)
+	if err != nil {
+		return err
+	}
+	for _, section := range sectionNames() {
+		if !contains(reloadableSections, section) && !reflect.DeepEqual(c[section], loadedConfig[section]) {
+			log.Printf("Changes to the %v config section require a restart\n", section)
+		}
+	}
+	if err := applyConfig(flag.CommandLine, c, loadedConfig, reloadableSections, configOverrides); err != nil {
+		return fmt.Errorf("%v: %v", configFile, err)
+	}
+	loadedConfig = c
+	return nil
+}
+
+// watchConfig signals a reload on SIGHUP or when the config file changes
+func watchConfig(path string) <-chan bool {
+	reloads := make(chan bool)
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		modified := configModified(path)
+		ticker := time.NewTicker(configPollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-hup:
+			case <-ticker.C:
+				m := configModified(path)
+				if m.Equal(modified) {
+					continue
+				}
+				modified = m
+			}
+			reloads <- true
+		}
+	}()
+	return reloads
+}
+
+func configModified(path string) time.Time {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+func sectionNames() []string {
+	names := make([]string, 0, len(configSections))
+	for name := range configSections {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testFlags struct {
+	batchsize   int
+	dedup       bool
+	rateLimit   float64
+	filterRules string
+	bucket      string
+}
+
+func testFlagSet() (*flag.FlagSet, *testFlags) {
+	values := &testFlags{}
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.IntVar(&values.batchsize, "batchsize", 10, "")
+	fs.BoolVar(&values.dedup, "dedup", false, "")
+	fs.Float64Var(&values.rateLimit, "rateLimit", 0, "")
+	fs.StringVar(&values.filterRules, "filterRules", "", "")
+	fs.StringVar(&values.bucket, "bucketName", "", "")
+	return fs, values
+}
+
+func writeConfig(t *testing.T, dir, content string) string {
+	path := filepath.Join(dir, "config.json")
+	assert.NoError(t, ioutil.WriteFile(path, []byte(content), 0644))
+	return path
+}
+
+func Test_Config_PrecedenceOfFlagsEnvAndFile(t *testing.T) {
+	fs, values := testFlagSet()
+	assert.NoError(t, fs.Parse([]string{"-bucketName=from-flag"}))
+	overrides := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { overrides[f.Name] = true })
+
+	env := map[string]string{"FORWARDER_RATE_LIMIT": "20", "FORWARDER_BUCKET_NAME": "from-env"}
+	assert.NoError(t, applyEnv(fs, func(name string) string { return env[name] }, overrides))
+
+	c, err := loadConfig("testdata/config.json", fs)
+	assert.NoError(t, err)
+	assert.NoError(t, applyConfig(fs, c, nil, sectionNames(), overrides))
+
+	assert.Equal(t, &testFlags{batchsize: 50, dedup: true, rateLimit: 20, filterRules: "testdata/filters.json", bucket: "from-flag"}, values)
+}
+
+func Test_Config_ReloadResetsRemovedSettings(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	fs, values := testFlagSet()
+
+	previous, err := loadConfig(writeConfig(t, dir, `{"stages": {"dedup": true, "filterRules": "filters.json"}, "outputs": {"batchsize": 50}}`), fs)
+	assert.NoError(t, err)
+	assert.NoError(t, applyConfig(fs, previous, nil, sectionNames(), map[string]bool{}))
+
+	c, err := loadConfig(writeConfig(t, dir, `{"stages": {"filterRules": "other.json"}, "outputs": {"batchsize": 20}}`), fs)
+	assert.NoError(t, err)
+	assert.NoError(t, applyConfig(fs, c, previous, reloadableSections, map[string]bool{}))
+
+	assert.False(t, values.dedup)
+	assert.Equal(t, "other.json", values.filterRules)
+	assert.Equal(t, 50, values.batchsize)
+}
+
+func Test_LoadConfig_Invalid(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	fs, _ := testFlagSet()
+
+	for content, message := range map[string]string{
+		`{"outputs": {"batchsize": "ten"}}`: `outputs.batchsize: expected an integer, got "ten"`,
+		`{"outputs": {"batchsize": 1.5}}`:   `outputs.batchsize: expected an integer, got 1.5`,
+		`{"stages": {"dedup": "yes"}}`:      `stages.dedup: expected true or false, got "yes"`,
+		`{"stages": {"rateLimit": true}}`:   `stages.rateLimit: expected a number, got true`,
+		`{"stages": {"filterRules": 1}}`:    `stages.filterRules: expected a string, got 1`,
+		`{"stages": {"batchsize": 10}}`:     `stages.batchsize: unknown setting`,
+		`{"output": {"batchsize": 10}}`:     `unknown section "output", expected one of inputs, metrics, outputs, retry, stages`,
+		`{"outputs": {"batchsize": 10}`:     `unexpected EOF`,
+	} {
+		path := writeConfig(t, dir, content)
+		_, err := loadConfig(path, fs)
+		if assert.Error(t, err, content) {
+			assert.Equal(t, path+": "+message, err.Error())
+		}
+	}
+}
+
+func Test_EnvName(t *testing.T) {
+	assert.Equal(t, "FORWARDER_BATCHSIZE", envName("batchsize"))
+	assert.Equal(t, "FORWARDER_BUCKET_NAME", envName("bucketName"))
+	assert.Equal(t, "FORWARDER_RATE_LIMIT_SAMPLE_RATE", envName("rateLimitSampleRate"))
+}
+
+func Test_ConfigSections_SetExistingFlags(t *testing.T) {
+	for section, names := range configSections {
+		for _, name := range names {
+			assert.NotNil(t, flag.Lookup(name), "%v.%v", section, name)
+		}
+	}
+}
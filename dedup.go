@@ -0,0 +1,183 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+const (
+	dedupStateFile = "dedup.json"
+	// the cache is written to disk at most this often while running, and on exit
+	dedupSaveInterval = 30 * time.Second
+)
+
+var (
+	dedup       bool
+	dedupField  string
+	dedupWindow int
+	dedupSize   int
+	stateDir    string
+)
+
+// deduplicator drops events seen within the window, identified by a field such as journald's __CURSOR or by a hash of
+// their content. It remembers at most size fingerprints and, given a path, persists them across restarts.
+type deduplicator struct {
+	field    string
+	window   time.Duration
+	size     int
+	seen     map[string]time.Time
+	order    []dedupEntry
+	path     string
+	lastSave time.Time
+	dropped  metrics.Counter
+	now      func() time.Time
+}
+
+type dedupEntry struct {
+	Fingerprint string    `json:"fingerprint"`
+	Seen        time.Time `json:"seen"`
+}
+
+func newDeduplicator(field string, window time.Duration, size int, path string) (*deduplicator, error) {
+	if window <= 0 || size < 1 {
+		return nil, fmt.Errorf("dedup window and size must be positive")
+	}
+	d := &deduplicator{
+		field:   field,
+		window:  window,
+		size:    size,
+		seen:    map[string]time.Time{},
+		path:    path,
+		dropped: metrics.GetOrRegisterCounter("dedup.dropped", metrics.DefaultRegistry),
+		now:     time.Now,
+	}
+	if path != "" {
+		if err := d.load(); err != nil {
+			return nil, err
+		}
+	}
+	return d, nil
+}
+
+func (d *deduplicator) process(e *logEvent, emit func(*logEvent)) {
+	now := d.now()
+	fingerprint := d.fingerprint(e)
+	if seen, found := d.seen[fingerprint]; found && now.Sub(seen) < d.window {
+		d.dropped.Inc(1)
+		e.done()
+		return
+	}
+	d.remember(fingerprint, now)
+	emit(e)
+}
+
+func (d *deduplicator) fingerprint(e *logEvent) string {
+	if d.field != "" {
+		if value, found := e.Field(d.field); found && value != "" {
+			return d.field + ":" + value
+		}
+	}
+	sum := sha1.Sum([]byte(e.raw))
+	return hex.EncodeToString(sum[:])
+}
+
+func (d *deduplicator) remember(fingerprint string, now time.Time) {
+	d.seen[fingerprint] = now
+	d.order = append(d.order, dedupEntry{fingerprint, now})
+	for len(d.seen) > d.size {
+		d.evict()
+	}
+}
+
+// evict forgets the oldest fingerprint. Entries superseded by a later sighting of the same fingerprint are skipped.
+func (d *deduplicator) evict() {
+	oldest := d.order[0]
+	d.order = d.order[1:]
+	if d.seen[oldest.Fingerprint].Equal(oldest.Seen) {
+		delete(d.seen, oldest.Fingerprint)
+	}
+}
+
+// tick forgets the fingerprints which fell out of the window and saves the cache now and then
+func (d *deduplicator) tick(now time.Time, emit func(*logEvent)) {
+	for len(d.order) > 0 && now.Sub(d.order[0].Seen) >= d.window {
+		d.evict()
+	}
+	if d.path != "" && now.Sub(d.lastSave) >= dedupSaveInterval {
+		d.lastSave = now
+		if err := d.save(); err != nil {
+			log.Printf("Failed to save dedup cache: %v\n", err)
+		}
+	}
+}
+
+func (d *deduplicator) flush(emit func(*logEvent)) {
+	if d.path != "" {
+		if err := d.save(); err != nil {
+			log.Printf("Failed to save dedup cache: %v\n", err)
+		}
+	}
+}
+
+func (d *deduplicator) load() error {
+	data, err := ioutil.ReadFile(d.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	entries := []dedupEntry{}
+	if err := json.Unmarshal(data, &entries); err != nil {
+		log.Printf("Ignoring unreadable dedup cache %v: %v\n", d.path, err)
+		return nil
+	}
+	now := d.now()
+	for _, entry := range entries {
+		if now.Sub(entry.Seen) < d.window {
+			d.remember(entry.Fingerprint, entry.Seen)
+		}
+	}
+	return nil
+}
+
+func (d *deduplicator) save() error {
+	entries := make([]dedupEntry, 0, len(d.seen))
+	for _, entry := range d.order {
+		if d.seen[entry.Fingerprint].Equal(entry.Seen) {
+			entries = append(entries, entry)
+		}
+	}
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(d.path, data)
+}
+
+// writeFileAtomic replaces a file without leaving it half written if the process dies
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
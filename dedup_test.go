@@ -0,0 +1,91 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/stretchr/testify/assert"
+)
+
+func testDeduplicator(t *testing.T, size int, path string) (*deduplicator, *rateLimitClock) {
+	d, err := newDeduplicator("__CURSOR", time.Minute, size, path)
+	assert.NoError(t, err)
+	// the cache is loaded with the real clock
+	clock := &rateLimitClock{time.Now()}
+	d.now = clock.now
+	return d, clock
+}
+
+func Test_Deduplicator_DropsRepeatedCursorsAndContent(t *testing.T) {
+	d, clock := testDeduplicator(t, 100, "")
+	dropped := metrics.GetOrRegisterCounter("dedup.dropped", metrics.DefaultRegistry).Count()
+
+	emitted := []string{}
+	emit := func(e *logEvent) { emitted = append(emitted, e.raw) }
+	for _, line := range []string{
+		`{"__CURSOR":"s=1;i=1","MESSAGE":"first"}`,
+		`{"__CURSOR":"s=1;i=2","MESSAGE":"first"}`,
+		`{"__CURSOR":"s=1;i=1","MESSAGE":"replayed"}`,
+		"plain line\n",
+		"plain line\n",
+		"other line\n",
+	} {
+		d.process(newLogEvent(line), emit)
+	}
+	assert.Equal(t, []string{
+		`{"__CURSOR":"s=1;i=1","MESSAGE":"first"}`,
+		`{"__CURSOR":"s=1;i=2","MESSAGE":"first"}`,
+		"plain line\n",
+		"other line\n",
+	}, emitted)
+	assert.Equal(t, dropped+2, metrics.GetOrRegisterCounter("dedup.dropped", metrics.DefaultRegistry).Count())
+
+	clock.t = clock.t.Add(time.Minute)
+	d.tick(clock.t, emit)
+	assert.Empty(t, d.seen)
+	d.process(newLogEvent("plain line\n"), emit)
+	assert.Len(t, emitted, 5)
+}
+
+func Test_Deduplicator_IsBounded(t *testing.T) {
+	d, _ := testDeduplicator(t, 2, "")
+
+	count := 0
+	emit := func(e *logEvent) { count++ }
+	for _, line := range []string{"a\n", "b\n", "a\n", "c\n", "b\n", "a\n"} {
+		d.process(newLogEvent(line), emit)
+	}
+	// the oldest event a is forgotten when c arrives, b is still remembered
+	assert.Equal(t, 4, count)
+	assert.Len(t, d.seen, 2)
+}
+
+func Test_Deduplicator_PersistsAcrossRestarts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dedup")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, dedupStateFile)
+
+	d, _ := testDeduplicator(t, 100, path)
+	count := 0
+	emit := func(e *logEvent) { count++ }
+	d.process(newLogEvent(`{"__CURSOR":"s=1;i=1"}`), emit)
+	d.process(newLogEvent(`{"__CURSOR":"s=1;i=2"}`), emit)
+	d.flush(emit)
+
+	restarted, _ := testDeduplicator(t, 100, path)
+	restarted.process(newLogEvent(`{"__CURSOR":"s=1;i=2"}`), emit)
+	restarted.process(newLogEvent(`{"__CURSOR":"s=1;i=3"}`), emit)
+	assert.Equal(t, 3, count)
+}
+
+func Test_NewDeduplicator_Invalid(t *testing.T) {
+	_, err := newDeduplicator("", 0, 100, "")
+	assert.Error(t, err)
+	_, err = newDeduplicator("", time.Minute, 0, "")
+	assert.Error(t, err)
+}
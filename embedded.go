@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+const (
+	collisionKeep      = "keep"
+	collisionOverwrite = "overwrite"
+	collisionPrefix    = "prefix"
+)
+
+var (
+	embeddedField     string
+	embeddedFormats   string
+	embeddedCollision string
+)
+
+// decodeEmbedded decodes a json or logfmt payload held by one field of the event and merges its keys into the event.
+// The field is left untouched when its value can not be decoded.
+func decodeEmbedded(e *logEvent, field string, formats []string, collision string) {
+	value, found := e.Fields()[field]
+	if !found {
+		return
+	}
+	s, ok := value.(string)
+	if !ok {
+		return
+	}
+
+	var payload map[string]interface{}
+	for _, format := range formats {
+		switch format {
+		case "json":
+			payload = parseEmbeddedJSON(s)
+		case "logfmt":
+			payload = parseLogfmt(s)
+		}
+		if payload != nil {
+			break
+		}
+	}
+	if payload == nil {
+		return
+	}
+
+	e.DeleteField(field)
+	for k, v := range payload {
+		if _, exists := e.fields[k]; exists {
+			switch collision {
+			case collisionOverwrite:
+			case collisionPrefix:
+				k = field + "." + k
+			default:
+				continue
+			}
+		}
+		e.fields[k] = v
+	}
+	e.modified = true
+}
+
+func validateEmbedded(formats []string, collision string) error {
+	for _, format := range formats {
+		if format != "json" && format != "logfmt" {
+			return fmt.Errorf("unknown embedded format %q, expected json or logfmt", format)
+		}
+	}
+	switch collision {
+	case collisionKeep, collisionOverwrite, collisionPrefix:
+		return nil
+	}
+	return fmt.Errorf("unknown collision rule %q, expected one of %v, %v, %v", collision, collisionKeep, collisionOverwrite, collisionPrefix)
+}
+
+func parseEmbeddedJSON(s string) map[string]interface{} {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, "{") {
+		return nil
+	}
+	d := json.NewDecoder(strings.NewReader(s))
+	d.UseNumber()
+	payload := map[string]interface{}{}
+	if err := d.Decode(&payload); err != nil || d.More() {
+		return nil
+	}
+	return payload
+}
+
+// parseLogfmt decodes a line of key=value pairs. Values may be double quoted.
+// Lines containing anything but key=value pairs are rejected, so plain text messages are not mistaken for logfmt.
+func parseLogfmt(s string) map[string]interface{} {
+	payload := map[string]interface{}{}
+	i := 0
+	for {
+		for i < len(s) && s[i] == ' ' {
+			i++
+		}
+		if i == len(s) {
+			break
+		}
+
+		start := i
+		for i < len(s) && s[i] != '=' && s[i] != ' ' && s[i] != '"' {
+			i++
+		}
+		if i == start || i == len(s) || s[i] != '=' {
+			return nil
+		}
+		key := s[start:i]
+		i++
+
+		var value string
+		if i < len(s) && s[i] == '"' {
+			end := i + 1
+			for end < len(s) && s[end] != '"' {
+				if s[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(s) {
+				return nil
+			}
+			if err := json.Unmarshal([]byte(s[i:end+1]), &value); err != nil {
+				return nil
+			}
+			i = end + 1
+		} else {
+			start = i
+			for i < len(s) && s[i] != ' ' {
+				i++
+			}
+			value = s[start:i]
+		}
+		payload[key] = value
+	}
+	if len(payload) == 0 {
+		return nil
+	}
+	return payload
+}
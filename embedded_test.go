@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_DecodeEmbedded_JSON(t *testing.T) {
+	e := &logEvent{raw: `{"SYSTEMD_UNIT":"annotations-mapper@2.service","MESSAGE":"{\"level\":\"info\",\"msg\":\"Successfully mapped\",\"transaction_id\":\"tid_rahiuyzv8d\"}"}`}
+	decodeEmbedded(e, "MESSAGE", []string{"json", "logfmt"}, collisionKeep)
+
+	assert.True(t, e.modified)
+	assert.Equal(t, map[string]interface{}{
+		"SYSTEMD_UNIT":   "annotations-mapper@2.service",
+		"level":          "info",
+		"msg":            "Successfully mapped",
+		"transaction_id": "tid_rahiuyzv8d",
+	}, e.fields)
+}
+
+func Test_DecodeEmbedded_Logfmt(t *testing.T) {
+	e := &logEvent{raw: `{"MESSAGE":"level=error msg=\"failed to map\" status=503"}`}
+	decodeEmbedded(e, "MESSAGE", []string{"json", "logfmt"}, collisionKeep)
+
+	assert.Equal(t, map[string]interface{}{"level": "error", "msg": "failed to map", "status": "503"}, e.fields)
+}
+
+func Test_DecodeEmbedded_Collisions(t *testing.T) {
+	raw := `{"level":"info","MESSAGE":"{\"level\":\"error\"}"}`
+
+	e := &logEvent{raw: raw}
+	decodeEmbedded(e, "MESSAGE", []string{"json"}, collisionKeep)
+	assert.Equal(t, map[string]interface{}{"level": "info"}, e.fields)
+
+	e = &logEvent{raw: raw}
+	decodeEmbedded(e, "MESSAGE", []string{"json"}, collisionOverwrite)
+	assert.Equal(t, map[string]interface{}{"level": "error"}, e.fields)
+
+	e = &logEvent{raw: raw}
+	decodeEmbedded(e, "MESSAGE", []string{"json"}, collisionPrefix)
+	assert.Equal(t, map[string]interface{}{"level": "info", "MESSAGE.level": "error"}, e.fields)
+}
+
+func Test_DecodeEmbedded_KeepsUnparseableValue(t *testing.T) {
+	for _, message := range []string{"Successfully mapped", `{"broken":`, `level=info and some text`, `msg="unterminated`} {
+		e := &logEvent{raw: `{"MESSAGE":` + jsonString(message) + `}`}
+		decodeEmbedded(e, "MESSAGE", []string{"json", "logfmt"}, collisionKeep)
+
+		assert.False(t, e.modified, message)
+		assert.Equal(t, message, e.fields["MESSAGE"])
+	}
+}
+
+func Test_ValidateEmbedded(t *testing.T) {
+	assert.NoError(t, validateEmbedded([]string{"json", "logfmt"}, collisionPrefix))
+	assert.Error(t, validateEmbedded([]string{"xml"}, collisionKeep))
+	assert.Error(t, validateEmbedded([]string{"json"}, "merge"))
+}
+
+func jsonString(s string) string {
+	b, _ := json.Marshal(s)
+	return string(b)
+}
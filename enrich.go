@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+var (
+	enrichFlag       string
+	enrichHostFacts  bool
+	metadataProvider string
+	metadataFile     string
+	lookupTablesFlag string
+)
+
+// instanceMetadata provides facts about the instance running the forwarder, e.g. its cloud instance id and region
+type instanceMetadata interface {
+	metadata() (map[string]string, error)
+}
+
+// metadataProviders creates the instance metadata provider named by -metadataProvider
+var metadataProviders = map[string]func() instanceMetadata{
+	"file": func() instanceMetadata { return fileMetadata(metadataFile) },
+}
+
+// fileMetadata reads instance facts from a json object, standing in for a cloud metadata service
+type fileMetadata string
+
+func (path fileMetadata) metadata() (map[string]string, error) {
+	f, err := os.Open(string(path))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	facts := map[string]string{}
+	if err := json.NewDecoder(f).Decode(&facts); err != nil {
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+	return facts, nil
+}
+
+// lookupTable adds the fields found in a table by the value of an event field, e.g. the team owning a SYSTEMD_UNIT
+type lookupTable struct {
+	field string
+	rows  map[string]map[string]interface{}
+}
+
+// enricher adds static fields, host facts and looked up fields to events. Fields already in an event are kept.
+// Json events get the fields in their body, other events as HEC indexed fields.
+type enricher struct {
+	fields map[string]interface{}
+	tables []*lookupTable
+}
+
+func newEnricher(fields map[string]interface{}, tables []*lookupTable) *enricher {
+	return &enricher{fields: fields, tables: tables}
+}
+
+func (n *enricher) process(e *logEvent, emit func(*logEvent)) {
+	for k, v := range n.fields {
+		n.add(e, k, v)
+	}
+	for _, table := range n.tables {
+		key, found := e.Field(table.field)
+		if !found {
+			continue
+		}
+		for k, v := range table.rows[key] {
+			n.add(e, k, v)
+		}
+	}
+	emit(e)
+}
+
+func (n *enricher) add(e *logEvent, name string, value interface{}) {
+	fields := e.Fields()
+	if fields == nil {
+		if e.indexed == nil {
+			e.indexed = map[string]interface{}{}
+		}
+		if _, found := e.indexed[name]; !found {
+			e.indexed[name] = indexedValue(value)
+		}
+		return
+	}
+	if _, found := fields[name]; !found {
+		fields[name] = value
+		e.modified = true
+	}
+}
+
+// loadLookupTable reads a csv file whose first column is the key and header names the fields, or a json object mapping
+// keys to objects of fields
+func loadLookupTable(field, path string) (*lookupTable, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	table := &lookupTable{field: field, rows: map[string]map[string]interface{}{}}
+	if strings.ToLower(filepath.Ext(path)) == ".json" {
+		d := json.NewDecoder(f)
+		d.UseNumber()
+		if err := d.Decode(&table.rows); err != nil {
+			return nil, fmt.Errorf("%v: %v", path, err)
+		}
+		return table, nil
+	}
+	r := csv.NewReader(f)
+	header, err := r.Read()
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+	if len(header) < 2 {
+		return nil, fmt.Errorf("%v: expected a key column and at least one field column", path)
+	}
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%v: %v", path, err)
+		}
+		row := map[string]interface{}{}
+		for i, name := range header[1:] {
+			if value := record[i+1]; value != "" {
+				row[name] = value
+			}
+		}
+		table.rows[record[0]] = row
+	}
+	return table, nil
+}
+
+// parseLookupTables reads comma separated field=path pairs
+func parseLookupTables(s string) ([]*lookupTable, error) {
+	tables := []*lookupTable{}
+	for _, pair := range splitList(s) {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+			return nil, fmt.Errorf("invalid lookup table %q, expected field=path", pair)
+		}
+		table, err := loadLookupTable(kv[0], kv[1])
+		if err != nil {
+			return nil, err
+		}
+		tables = append(tables, table)
+	}
+	return tables, nil
+}
+
+// enrichmentFromFlags returns the enrichment stage configured by the flags, or nil if there is nothing to add
+func enrichmentFromFlags() (*enricher, error) {
+	fields, err := parseStaticFields(enrichFlag)
+	if err != nil {
+		return nil, err
+	}
+	if enrichHostFacts {
+		fields["hostname"] = hostname
+		fields["env"] = env
+	}
+	if metadataProvider != "" {
+		newProvider, found := metadataProviders[metadataProvider]
+		if !found {
+			return nil, fmt.Errorf("unknown metadata provider %q, expected one of %v", metadataProvider, providerNames())
+		}
+		facts, err := newProvider().metadata()
+		if err != nil {
+			return nil, fmt.Errorf("instance metadata: %v", err)
+		}
+		for k, v := range facts {
+			if _, found := fields[k]; !found {
+				fields[k] = v
+			}
+		}
+	}
+	tables, err := parseLookupTables(lookupTablesFlag)
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) == 0 && len(tables) == 0 {
+		return nil, nil
+	}
+	return newEnricher(fields, tables), nil
+}
+
+func providerNames() []string {
+	names := make([]string, 0, len(metadataProviders))
+	for name := range metadataProviders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
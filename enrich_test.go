@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func withEnrichment(fields string, hostFacts bool, provider, file, tables string) func() {
+	oldFields, oldHostFacts, oldProvider, oldFile, oldTables := enrichFlag, enrichHostFacts, metadataProvider, metadataFile, lookupTablesFlag
+	enrichFlag, enrichHostFacts, metadataProvider, metadataFile, lookupTablesFlag = fields, hostFacts, provider, file, tables
+	return func() {
+		enrichFlag, enrichHostFacts, metadataProvider, metadataFile, lookupTablesFlag = oldFields, oldHostFacts, oldProvider, oldFile, oldTables
+	}
+}
+
+func enrich(n *enricher, line string) *logEvent {
+	var enriched *logEvent
+	n.process(newLogEvent(line), func(e *logEvent) { enriched = e })
+	return enriched
+}
+
+func Test_Enricher_AddsStaticFieldsHostFactsAndMetadata(t *testing.T) {
+	defer withEnrichment("cluster=${env}-delivery,team=platform", true, "file", "testdata/metadata.json", "")()
+	oldHostname, oldEnv := hostname, env
+	hostname, env = "ip-10-0-0-1", "prod"
+	defer func() { hostname, env = oldHostname, oldEnv }()
+
+	n, err := enrichmentFromFlags()
+	assert.NoError(t, err)
+
+	e := enrich(n, `{"MESSAGE":"started","team":"content"}`)
+	assert.Equal(t, map[string]interface{}{
+		"MESSAGE":           "started",
+		"team":              "content",
+		"cluster":           "prod-delivery",
+		"hostname":          "ip-10-0-0-1",
+		"env":               "prod",
+		"instance_id":       "i-0123456789abcdef0",
+		"region":            "eu-west-1",
+		"availability_zone": "eu-west-1a",
+	}, e.hec().Event)
+
+	e = enrich(n, "plain line\n")
+	assert.Equal(t, "plain line\n", e.hec().Event)
+	assert.Equal(t, "platform", e.indexed["team"])
+	assert.Equal(t, "eu-west-1", e.indexed["region"])
+}
+
+func Test_Enricher_LooksUpTables(t *testing.T) {
+	defer withEnrichment("", false, "", "", "SYSTEMD_UNIT=testdata/teams.csv, _SYSTEMD_UNIT=testdata/teams.json")()
+	n, err := enrichmentFromFlags()
+	assert.NoError(t, err)
+
+	e := enrich(n, `{"SYSTEMD_UNIT":"content-api@1.service"}`)
+	assert.Equal(t, "content", e.fields["team"])
+	assert.Equal(t, "#content-team", e.fields["slack"])
+
+	e = enrich(n, `{"SYSTEMD_UNIT":"annotations-mapper@2.service"}`)
+	assert.Equal(t, "annotations", e.fields["team"])
+	assert.NotContains(t, e.fields, "slack")
+
+	e = enrich(n, `{"_SYSTEMD_UNIT":"content-api@1.service"}`)
+	assert.Equal(t, "content", e.fields["team"])
+	assert.Equal(t, json.Number("1"), e.fields["tier"])
+
+	e = enrich(n, `{"SYSTEMD_UNIT":"unknown.service"}`)
+	assert.False(t, e.modified)
+}
+
+func Test_EnrichmentFromFlags_Invalid(t *testing.T) {
+	defer withEnrichment("", false, "", "", "")()
+	n, err := enrichmentFromFlags()
+	assert.NoError(t, err)
+	assert.Nil(t, n)
+
+	metadataProvider = "ec2"
+	_, err = enrichmentFromFlags()
+	assert.Error(t, err)
+
+	metadataProvider, metadataFile = "file", "testdata/missing.json"
+	_, err = enrichmentFromFlags()
+	assert.Error(t, err)
+
+	metadataProvider, lookupTablesFlag = "", "testdata/teams.csv"
+	_, err = enrichmentFromFlags()
+	assert.Error(t, err)
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// logEvent is a single log line on its way to Splunk, together with the HEC metadata derived from it
+type logEvent struct {
+	raw        string
+	fields     map[string]interface{}
+	parsed     bool
+	modified   bool // fields were changed and have to be sent instead of raw
+	time       time.Time
+	precision  time.Duration
+	host       string
+	source     string
+	sourcetype string
+	index      string
+	indexed    map[string]interface{}
+	metric     map[string]interface{} // HEC metric fields, set when the event is a metric
+	acks       []func()               // called once the event is delivered, cached for retry or dropped
+	decorated  bool
+}
+
+// hecEvent is the json document accepted by the Splunk HEC event endpoint
+type hecEvent struct {
+	Event      interface{}            `json:"event"`
+	Time       float64                `json:"time"`
+	Host       string                 `json:"host,omitempty"`
+	Source     string                 `json:"source,omitempty"`
+	Sourcetype string                 `json:"sourcetype,omitempty"`
+	Index      string                 `json:"index,omitempty"`
+	Fields     map[string]interface{} `json:"fields,omitempty"`
+}
+
+func newLogEvent(line string) *logEvent {
+	e := &logEvent{raw: line, precision: time.Millisecond}
+	if journald {
+		parseJournald(e)
+	}
+	if embeddedField != "" {
+		decodeEmbedded(e, embeddedField, strings.Split(embeddedFormats, ","), embeddedCollision)
+	}
+	if e.time.IsZero() {
+		e.time = extractTimestamp(e)
+	}
+	return e
+}
+
+// decorate derives the HEC metadata depending on the final content of the event: route, indexed fields and metric fields.
+// It only does so once, as the copies of an event sent to several outputs share it.
+func (e *logEvent) decorate() {
+	if e.decorated {
+		return
+	}
+	e.decorated = true
+	if eventRouter != nil {
+		eventRouter.route(e)
+	}
+	addIndexedFields(e)
+	detectMetric(e)
+}
+
+// newFieldsEvent creates an event from fields, e.g. for events generated by the forwarder itself
+func newFieldsEvent(fields map[string]interface{}, t time.Time) *logEvent {
+	raw, _ := json.Marshal(fields)
+	return &logEvent{raw: string(raw), fields: fields, parsed: true, modified: true, time: t, precision: time.Millisecond}
+}
+
+// Fields lazily decodes the raw line as a json object. It returns nil if the line is not json.
+func (e *logEvent) Fields() map[string]interface{} {
+	if e.parsed {
+		return e.fields
+	}
+	e.parsed = true
+	d := json.NewDecoder(strings.NewReader(e.raw))
+	d.UseNumber()
+	fields := map[string]interface{}{}
+	if err := d.Decode(&fields); err != nil {
+		return nil
+	}
+	e.fields = fields
+	return e.fields
+}
+
+// Field returns the string value of a top level field of the parsed event
+func (e *logEvent) Field(name string) (string, bool) {
+	v, found := e.Fields()[name]
+	if !found {
+		return "", false
+	}
+	return fieldString(v), true
+}
+
+// DeleteField removes a field from the event body
+func (e *logEvent) DeleteField(name string) {
+	if _, found := e.Fields()[name]; found {
+		delete(e.fields, name)
+		e.modified = true
+	}
+}
+
+// done acknowledges the event to the inputs waiting for it, e.g. to advance the offset of a tailed file. Stages dropping an
+// event call it, delivered events are acknowledged with their batch.
+func (e *logEvent) done() {
+	for _, ack := range e.acks {
+		ack()
+	}
+	e.acks = nil
+}
+
+func (e *logEvent) hec() hecEvent {
+	var event interface{} = e.raw
+	if e.modified {
+		event = e.fields
+	}
+	item := hecEvent{
+		Event:      event,
+		Time:       epochSeconds(e.time, e.precision),
+		Host:       e.host,
+		Source:     e.source,
+		Sourcetype: e.sourcetype,
+		Index:      e.index,
+		Fields:     e.indexed,
+	}
+	if e.metric != nil {
+		item.Event = "metric"
+		item.Fields = map[string]interface{}{}
+		for k, v := range e.indexed {
+			item.Fields[k] = v
+		}
+		for k, v := range e.metric {
+			item.Fields[k] = v
+		}
+	}
+	return item
+}
+
+// For Splunk HEC, the default time format is epoch time format, in the format <sec>.<ms>.
+// For example, 1433188255.500 indicates 1433188255 seconds and 500 milliseconds after epoch, or Monday, June 1, 2015, at 7:50:55 PM GMT.
+func epochSeconds(t time.Time, precision time.Duration) float64 {
+	digits := 3
+	if precision < time.Millisecond {
+		digits = 6
+		precision = time.Microsecond
+	} else {
+		precision = time.Millisecond
+	}
+	epoch, err := strconv.ParseFloat(fmt.Sprintf("%d.%0*d", t.Unix(), digits, t.Nanosecond()/int(precision)), 64)
+	if err != nil {
+		epoch = float64(t.UnixNano()) / float64(time.Second)
+	}
+	return epoch
+}
+
+func fieldString(v interface{}) string {
+	switch val := v.(type) {
+	case string:
+		return val
+	case nil:
+		return ""
+	case fmt.Stringer:
+		return val.String()
+	case map[string]interface{}, []interface{}:
+		buf := &bytes.Buffer{}
+		enc := json.NewEncoder(buf)
+		enc.SetEscapeHTML(false)
+		if err := enc.Encode(val); err != nil {
+			return fmt.Sprint(val)
+		}
+		return strings.TrimSuffix(buf.String(), "\n")
+	default:
+		return fmt.Sprint(val)
+	}
+}
+
+func splitList(s string) []string {
+	list := []string{}
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
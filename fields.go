@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+var (
+	staticFieldsFlag string
+	liftFieldsFlag   string
+	maxFieldLength   int
+	staticFields     map[string]interface{}
+	liftFields       []string
+)
+
+// parseStaticFields reads comma separated key=value pairs. Values may refer to ${env} and ${hostname}.
+func parseStaticFields(s string) (map[string]interface{}, error) {
+	fields := map[string]interface{}{}
+	if strings.TrimSpace(s) == "" {
+		return fields, nil
+	}
+	for _, pair := range strings.Split(s, ",") {
+		kv := strings.SplitN(pair, "=", 2)
+		key := strings.TrimSpace(kv[0])
+		if len(kv) != 2 || key == "" {
+			return nil, fmt.Errorf("invalid field %q, expected key=value", pair)
+		}
+		fields[key] = os.Expand(strings.TrimSpace(kv[1]), func(name string) string {
+			switch name {
+			case "env":
+				return env
+			case "hostname":
+				return hostname
+			}
+			return ""
+		})
+	}
+	return fields, nil
+}
+
+func setupIndexedFields() error {
+	var err error
+	staticFields, err = parseStaticFields(staticFieldsFlag)
+	if err != nil {
+		return err
+	}
+	liftFields = splitList(liftFieldsFlag)
+	return nil
+}
+
+// addIndexedFields fills the HEC fields object with the static fields and the fields lifted from the event
+func addIndexedFields(e *logEvent) {
+	if len(staticFields) == 0 && len(liftFields) == 0 {
+		return
+	}
+	if e.indexed == nil {
+		e.indexed = map[string]interface{}{}
+	}
+	for k, v := range staticFields {
+		e.indexed[k] = indexedValue(v)
+	}
+	for _, field := range liftFields {
+		if v, found := e.Fields()[field]; found && v != nil {
+			e.indexed[field] = indexedValue(v)
+		}
+	}
+}
+
+// indexedValue converts a value to what HEC accepts as indexed field: a string or an array of strings.
+// Objects are flattened to their json form and values are truncated to -maxFieldLength bytes.
+func indexedValue(v interface{}) interface{} {
+	if values, ok := v.([]interface{}); ok {
+		strs := make([]string, 0, len(values))
+		for _, value := range values {
+			if value != nil {
+				strs = append(strs, truncateField(fieldString(value)))
+			}
+		}
+		return strs
+	}
+	if values, ok := v.([]string); ok {
+		strs := make([]string, 0, len(values))
+		for _, value := range values {
+			strs = append(strs, truncateField(value))
+		}
+		return strs
+	}
+	return truncateField(fieldString(v))
+}
+
+func truncateField(s string) string {
+	if maxFieldLength <= 0 {
+		return s
+	}
+	return truncateString(s, maxFieldLength)
+}
+
+// truncateString cuts s to at most max bytes without splitting a UTF-8 character
+func truncateString(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	cut := max
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	return s[:cut]
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func withIndexedFields(t *testing.T, static, lift string, maxLength int) func() {
+	staticFieldsFlag, liftFieldsFlag, maxFieldLength = static, lift, maxLength
+	assert.NoError(t, setupIndexedFields())
+	return func() {
+		staticFieldsFlag, liftFieldsFlag, maxFieldLength = "", "", 0
+		staticFields, liftFields = nil, nil
+	}
+}
+
+func Test_IndexedFields(t *testing.T) {
+	defer func(e, h string) { env, hostname = e, h }(env, hostname)
+	env, hostname = "prod-uk", "ip-10-172-40-48"
+	defer withIndexedFields(t, "environment=${env}, host_name=${hostname},team=content", "platform,service_name,transaction_id,status,tags,missing", 1024)()
+
+	e := newLogEvent(`{"platform":"up-coco","service_name":"annotations-mapper","transaction_id":"tid_rahiuyzv8d","status":503,"tags":["a",1,null,true]}`)
+	e.decorate()
+	doc, err := json.Marshal(e.hec())
+	assert.NoError(t, err)
+
+	actual := map[string]interface{}{}
+	assert.NoError(t, json.Unmarshal(doc, &actual))
+	assert.Equal(t, map[string]interface{}{
+		"environment":    "prod-uk",
+		"host_name":      "ip-10-172-40-48",
+		"team":           "content",
+		"platform":       "up-coco",
+		"service_name":   "annotations-mapper",
+		"transaction_id": "tid_rahiuyzv8d",
+		"status":         "503",
+		"tags":           []interface{}{"a", "1", "true"},
+	}, actual["fields"])
+}
+
+func Test_IndexedFields_FlattensObjectsAndTruncates(t *testing.T) {
+	defer withIndexedFields(t, "", "request,msg", 12)()
+
+	e := newLogEvent(`{"request":{"method":"GET"},"msg":"Successfully mapped ünicode"}`)
+	e.decorate()
+	assert.Equal(t, `{"method":"G`, e.indexed["request"])
+	assert.Equal(t, "Successfully", e.indexed["msg"])
+
+	e = newLogEvent(`{"msg":"Successfullü"}`)
+	e.decorate()
+	assert.Equal(t, "Successfull", e.indexed["msg"])
+}
+
+func Test_IndexedFields_NoneConfigured(t *testing.T) {
+	defer withIndexedFields(t, "", "", 1024)()
+
+	e := newLogEvent(`{"platform":"up-coco"}`)
+	e.decorate()
+	doc, err := json.Marshal(e.hec())
+	assert.NoError(t, err)
+	assert.False(t, strings.Contains(string(doc), `"fields"`))
+}
+
+func Test_ParseStaticFields_Invalid(t *testing.T) {
+	_, err := parseStaticFields("environment")
+	assert.Error(t, err)
+	_, err = parseStaticFields("=prod")
+	assert.Error(t, err)
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+const (
+	fileCheckpointFile = "files.json"
+	filePollInterval   = time.Second
+)
+
+var filePatterns string
+
+// fileCheckpoint is the acknowledged offset of a tailed file. While running, files are identified by inode so that a
+// file renamed by log rotation is recognised. On start, a checkpoint only applies to the file of the same path and inode,
+// as the inode of a deleted file may be reused by a new one.
+type fileCheckpoint struct {
+	Path   string `json:"path"`
+	Inode  uint64 `json:"inode"`
+	Offset int64  `json:"offset"`
+}
+
+// fileInput tails the files matching glob patterns, following them when they are renamed by log rotation and starting
+// over when they are truncated. The offset of a file is checkpointed once the events read before it are acknowledged,
+// i.e. accepted by Splunk, cached for retry or dropped on purpose.
+type fileInput struct {
+	patterns    []string
+	path        string
+	poll        time.Duration
+	files       map[uint64]*tailedFile
+	checkpoints map[uint64]fileCheckpoint
+	saved       []byte
+	decoder     lineDecoder
+	mu          sync.Mutex // guards the acknowledged offsets
+	lines       metrics.Counter
+}
+
+// lineDecoder turns the lines of tailed files into events. It attaches the acknowledgement of a line to the event which
+// delivers it, which is a later one when lines are reassembled.
+type lineDecoder interface {
+	decode(t *tailedFile, line string, ack func()) *logEvent
+	// close returns the events still held back for a file which is no longer read
+	close(t *tailedFile) []*logEvent
+}
+
+// plainLines decodes every line of a file into an event whose source is the file path
+type plainLines struct{}
+
+func (plainLines) decode(t *tailedFile, line string, ack func()) *logEvent {
+	e := newLogEvent(line)
+	if e.source == "" {
+		e.source = t.path
+	}
+	e.acks = append(e.acks, ack)
+	return e
+}
+
+func (plainLines) close(t *tailedFile) []*logEvent {
+	return nil
+}
+
+type tailedFile struct {
+	path   string
+	inode  uint64
+	f      *os.File
+	r      *bufio.Reader
+	offset int64
+	// the start of a line waiting for its newline, bounded by -maxMessageBytes, and the bytes read of it
+	partial      []byte
+	partialBytes int64
+	truncated    bool
+	// guarded by fileInput.mu
+	committed int64
+	pending   []*pendingLine
+}
+
+type pendingLine struct {
+	end   int64
+	acked bool
+}
+
+func newFileInput(patterns []string, checkpointPath string) (*fileInput, error) {
+	if len(patterns) == 0 {
+		return nil, fmt.Errorf("file input requires -files")
+	}
+	for _, pattern := range patterns {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("file pattern %q: %v", pattern, err)
+		}
+	}
+	in := &fileInput{
+		patterns:    patterns,
+		path:        checkpointPath,
+		poll:        filePollInterval,
+		files:       map[uint64]*tailedFile{},
+		checkpoints: map[uint64]fileCheckpoint{},
+		decoder:     plainLines{},
+		lines:       metrics.GetOrRegisterCounter("file_input.lines", metrics.DefaultRegistry),
+	}
+	data, err := ioutil.ReadFile(checkpointPath)
+	if os.IsNotExist(err) {
+		return in, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	checkpoints := []fileCheckpoint{}
+	if err := json.Unmarshal(data, &checkpoints); err != nil {
+		return nil, fmt.Errorf("%v: %v", checkpointPath, err)
+	}
+	for _, cp := range checkpoints {
+		in.checkpoints[cp.Inode] = cp
+	}
+	in.saved = data
+	return in, nil
+}
+
+func (in *fileInput) run(events chan<- *logEvent) error {
+	for {
+		in.scan(events)
+		if err := in.save(); err != nil {
+			log.Printf("Failed to save file checkpoints: %v\n", err)
+		}
+		time.Sleep(in.poll)
+	}
+}
+
+// scan picks up new, rotated and truncated files and reads the lines appended to them
+func (in *fileInput) scan(events chan<- *logEvent) {
+	seen := map[uint64]bool{}
+	for _, path := range in.match() {
+		info, err := os.Stat(path)
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		inode := fileInode(info)
+		if seen[inode] {
+			continue
+		}
+		seen[inode] = true
+		t, found := in.files[inode]
+		if !found {
+			if t, err = in.open(path); err != nil {
+				log.Printf("Failed to open %v: %v\n", path, err)
+				continue
+			}
+			in.files[t.inode] = t
+		}
+		t.path = path
+		if info.Size() < t.offset { // truncated in place, e.g. by copytruncate
+			if t, err = in.truncated(t, events); err != nil {
+				log.Printf("Failed to read %v from the start: %v\n", path, err)
+				continue
+			}
+		}
+		in.read(t, events, false)
+	}
+	// the checkpoints of the files gone since the last run are stale, their inodes may be reused by the files to come
+	in.checkpoints = map[uint64]fileCheckpoint{}
+	for inode, t := range in.files {
+		if !seen[inode] { // rotated out of the patterns or deleted: read what is left
+			in.read(t, events, true)
+			for _, e := range in.decoder.close(t) {
+				events <- e
+			}
+			t.f.Close()
+			delete(in.files, inode)
+		}
+	}
+}
+
+func (in *fileInput) match() []string {
+	paths := []string{}
+	for _, pattern := range in.patterns {
+		matches, _ := filepath.Glob(pattern)
+		paths = append(paths, matches...)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// open starts tailing a file from its checkpoint, or from the start if it has none. A checkpoint is used at most once.
+func (in *fileInput) open(path string) (*tailedFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	t := &tailedFile{path: path, inode: fileInode(info), f: f}
+	if cp, found := in.checkpoints[t.inode]; found {
+		delete(in.checkpoints, t.inode)
+		if cp.Path == path && cp.Offset <= info.Size() {
+			t.offset = cp.Offset
+		}
+	}
+	if _, err := f.Seek(t.offset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	t.r = bufio.NewReader(f)
+	t.committed = t.offset
+	return t, nil
+}
+
+// truncated replaces a truncated file by a fresh one reading from the start. Acknowledgements of the lines read before
+// only concern the replaced file.
+func (in *fileInput) truncated(t *tailedFile, events chan<- *logEvent) (*tailedFile, error) {
+	for _, e := range in.decoder.close(t) {
+		events <- e
+	}
+	if _, err := t.f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	fresh := &tailedFile{path: t.path, inode: t.inode, f: t.f, r: bufio.NewReader(t.f)}
+	in.files[t.inode] = fresh
+	return fresh, nil
+}
+
+// read sends the complete lines appended to a file. A partial last line waits for its newline, unless the file is
+// read for the last time. Lines longer than -maxMessageBytes are truncated, the rest of them being skipped.
+func (in *fileInput) read(t *tailedFile, events chan<- *logEvent, last bool) {
+	for {
+		chunk, err := t.r.ReadSlice('\n')
+		t.partialBytes += int64(len(chunk))
+		if err == nil {
+			chunk = chunk[:len(chunk)-1] // the newline does not count towards the length
+		}
+		t.partial, t.truncated = appendBounded(t.partial, chunk, maxMessageBytes, t.truncated)
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err == nil || (last && t.partialBytes > 0) {
+			line := string(t.partial)
+			if err == nil {
+				line += "\n"
+			}
+			truncated := t.truncated
+			t.offset += t.partialBytes
+			t.partial, t.partialBytes, t.truncated = nil, 0, false
+			if e := in.event(t, line); e != nil {
+				if truncated {
+					markTruncated(e)
+				}
+				events <- e
+			}
+		}
+		if err != nil {
+			if err != io.EOF {
+				log.Printf("Failed to read %v: %v\n", t.path, err)
+			}
+			return
+		}
+	}
+}
+
+func (in *fileInput) event(t *tailedFile, line string) *logEvent {
+	in.lines.Inc(1)
+	p := &pendingLine{end: t.offset}
+	in.mu.Lock()
+	t.pending = append(t.pending, p)
+	in.mu.Unlock()
+	return in.decoder.decode(t, line, func() { in.ack(t, p) })
+}
+
+// ack advances the offset of a file over the lines acknowledged in the order they were read
+func (in *fileInput) ack(t *tailedFile, p *pendingLine) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	p.acked = true
+	for len(t.pending) > 0 && t.pending[0].acked {
+		t.committed = t.pending[0].end
+		t.pending = t.pending[1:]
+	}
+}
+
+// offsets returns the acknowledged offsets of the tailed files
+func (in *fileInput) offsets() []fileCheckpoint {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	checkpoints := make([]fileCheckpoint, 0, len(in.files))
+	for _, t := range in.files {
+		checkpoints = append(checkpoints, fileCheckpoint{t.path, t.inode, t.committed})
+	}
+	sort.Slice(checkpoints, func(i, j int) bool { return checkpoints[i].Path < checkpoints[j].Path })
+	return checkpoints
+}
+
+func (in *fileInput) save() error {
+	data, err := json.Marshal(in.offsets())
+	if err != nil || bytes.Equal(data, in.saved) {
+		return err
+	}
+	if err := writeFileAtomic(in.path, data); err != nil {
+		return err
+	}
+	in.saved = data
+	return nil
+}
+
+func fileInode(info os.FileInfo) uint64 {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(stat.Ino)
+	}
+	return 0
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testFileInput(t *testing.T, dir string) *fileInput {
+	in, err := newFileInput([]string{filepath.Join(dir, "*.log")}, filepath.Join(dir, fileCheckpointFile))
+	assert.NoError(t, err)
+	return in
+}
+
+func appendFile(t *testing.T, path, content string) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	assert.NoError(t, err)
+	defer f.Close()
+	_, err = f.WriteString(content)
+	assert.NoError(t, err)
+}
+
+func scanFiles(in *fileInput) []*logEvent {
+	events := make(chan *logEvent, 100)
+	in.scan(events)
+	close(events)
+	read := []*logEvent{}
+	for e := range events {
+		read = append(read, e)
+	}
+	return read
+}
+
+func raws(events []*logEvent) []string {
+	lines := []string{}
+	for _, e := range events {
+		lines = append(lines, e.raw)
+	}
+	return lines
+}
+
+func savedCheckpoints(t *testing.T, dir, name string) []fileCheckpoint {
+	data, err := ioutil.ReadFile(filepath.Join(dir, name))
+	assert.NoError(t, err)
+	checkpoints := []fileCheckpoint{}
+	assert.NoError(t, json.Unmarshal(data, &checkpoints))
+	return checkpoints
+}
+
+func Test_FileInput_CheckpointsAcknowledgedOffsets(t *testing.T) {
+	dir, err := ioutil.TempDir("", "files")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "app.log")
+	appendFile(t, path, "first\nsecond\nthird\npartial")
+
+	in := testFileInput(t, dir)
+	events := scanFiles(in)
+	assert.Equal(t, []string{"first\n", "second\n", "third\n"}, raws(events))
+	assert.Equal(t, path, events[0].source)
+
+	events[1].done()
+	assert.NoError(t, in.save())
+	assert.Equal(t, int64(0), savedCheckpoints(t, dir, fileCheckpointFile)[0].Offset)
+
+	events[0].done()
+	assert.NoError(t, in.save())
+	assert.Equal(t, int64(len("first\nsecond\n")), savedCheckpoints(t, dir, fileCheckpointFile)[0].Offset)
+
+	appendFile(t, path, " line\n")
+	assert.Equal(t, []string{"partial line\n"}, raws(scanFiles(in)))
+
+	restarted := testFileInput(t, dir)
+	assert.Equal(t, []string{"third\n", "partial line\n"}, raws(scanFiles(restarted)))
+}
+
+func Test_FileInput_IgnoresStaleCheckpoints(t *testing.T) {
+	dir, err := ioutil.TempDir("", "files")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "app.log")
+	appendFile(t, path, "first\nsecond\n")
+	info, err := os.Stat(path)
+	assert.NoError(t, err)
+	inode := fileInode(info)
+
+	// the inode of a deleted file reused by another one
+	checkpoints, _ := json.Marshal([]fileCheckpoint{{filepath.Join(dir, "deleted.log"), inode, int64(len("first\n"))}})
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, fileCheckpointFile), checkpoints, 0644))
+	in := testFileInput(t, dir)
+	assert.Equal(t, []string{"first\n", "second\n"}, raws(scanFiles(in)))
+	assert.Empty(t, in.checkpoints, "checkpoints are only used on start")
+
+	// a checkpoint of the same path and inode is resumed from
+	checkpoints, _ = json.Marshal([]fileCheckpoint{{path, inode, int64(len("first\n"))}})
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, fileCheckpointFile), checkpoints, 0644))
+	in = testFileInput(t, dir)
+	assert.Equal(t, []string{"second\n"}, raws(scanFiles(in)))
+	assert.Empty(t, in.checkpoints)
+}
+
+func Test_FileInput_TruncatesLongLines(t *testing.T) {
+	defer withFraming(framingNewline, 8)()
+	dir, err := ioutil.TempDir("", "files")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "app.log")
+	long := strings.Repeat("x", 10000) // longer than the read buffer
+	appendFile(t, path, "short\n"+long)
+
+	in := testFileInput(t, dir)
+	oversized := oversizedMessages.Count()
+	first := scanFiles(in)
+	assert.Equal(t, []string{"short\n"}, raws(first))
+	first[0].done()
+	for _, tailed := range in.files {
+		assert.Len(t, tailed.partial, 8, "a line waiting for its newline is bounded too")
+	}
+
+	appendFile(t, path, "y\nnext\n")
+	events := scanFiles(in)
+	assert.Equal(t, []string{"xxxxxxxx\n", "next\n"}, raws(events))
+	assert.Equal(t, "true", events[0].indexed[truncatedField])
+	assert.Equal(t, oversized+1, oversizedMessages.Count())
+	for _, e := range events {
+		e.done()
+	}
+	assert.NoError(t, in.save())
+	assert.Equal(t, int64(len("short\n"+long+"y\nnext\n")), savedCheckpoints(t, dir, fileCheckpointFile)[0].Offset,
+		"the skipped bytes are checkpointed")
+}
+
+func Test_FileInput_FollowsRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "files")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "app.log")
+	appendFile(t, path, "before rotation\n")
+
+	in := testFileInput(t, dir)
+	assert.Equal(t, []string{"before rotation\n"}, raws(scanFiles(in)))
+
+	// rename: the rest of the old file is read before it is forgotten, the new file from its start
+	appendFile(t, path, "written late\n")
+	assert.NoError(t, os.Rename(path, filepath.Join(dir, "app.log.1")))
+	appendFile(t, path, "after rename\n")
+	assert.Equal(t, []string{"after rename\n", "written late\n"}, raws(scanFiles(in)))
+	assert.Len(t, in.files, 1)
+
+	// copytruncate: the file is read again from its start
+	assert.NoError(t, os.Truncate(path, 0))
+	assert.Empty(t, scanFiles(in))
+	appendFile(t, path, "after truncate\n")
+	events := scanFiles(in)
+	assert.Equal(t, []string{"after truncate\n"}, raws(events))
+
+	events[0].done()
+	assert.NoError(t, in.save())
+	assert.Equal(t, []fileCheckpoint{{path, in.offsets()[0].Inode, int64(len("after truncate\n"))}}, savedCheckpoints(t, dir, fileCheckpointFile))
+}
+
+func Test_FileInput_AcknowledgesDroppedAndMergedEvents(t *testing.T) {
+	dir, err := ioutil.TempDir("", "files")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	appendFile(t, filepath.Join(dir, "app.log"), "panic: boom\n  goroutine 1\n  main.go:12\n")
+
+	in := testFileInput(t, dir)
+	m, err := newMultiline("", `^\s`, "", "", 500, 65536, 1000000000)
+	assert.NoError(t, err)
+	merged := []*logEvent{}
+	for _, e := range scanFiles(in) {
+		m.process(e, func(e *logEvent) { merged = append(merged, e) })
+	}
+	m.flush(func(e *logEvent) { merged = append(merged, e) })
+	assert.Len(t, merged, 1)
+
+	b := batch{}
+	for _, e := range merged {
+		b.acks = append(b.acks, e.acks...)
+	}
+	b.ack()
+	assert.Equal(t, int64(len("panic: boom\n  goroutine 1\n  main.go:12\n")), in.offsets()[0].Offset)
+}
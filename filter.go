@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+const (
+	filterInclude = "include"
+	filterExclude = "exclude"
+)
+
+var (
+	filterRulesFile string
+	filterShadow    bool
+)
+
+// filterRule includes or excludes the events whose raw text matches regex and whose fields match all conditions.
+// Rules are evaluated in order and the first matching one decides. Events matching no rule are kept.
+type filterRule struct {
+	Name       string           `json:"name"`
+	Action     string           `json:"action"`
+	Regex      string           `json:"regex,omitempty"`
+	Conditions []routeCondition `json:"conditions,omitempty"`
+	regex      *regexp.Regexp
+	dropped    metrics.Counter
+}
+
+// filter drops events by rules. In shadow mode it only counts the events it would drop.
+type filter struct {
+	rules  []*filterRule
+	shadow bool
+}
+
+func loadFilterRules(path string) ([]filterRule, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	rules := []filterRule{}
+	if err := json.NewDecoder(f).Decode(&rules); err != nil {
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+	return rules, nil
+}
+
+func newFilter(rules []filterRule, shadow bool) (*filter, error) {
+	f := &filter{shadow: shadow}
+	for i, rule := range rules {
+		rule := rule
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule%v", i+1)
+		}
+		if rule.Action != filterInclude && rule.Action != filterExclude {
+			return nil, fmt.Errorf("filter rule %v: unknown action %q, expected %v or %v", rule.Name, rule.Action, filterInclude, filterExclude)
+		}
+		if rule.Regex != "" {
+			re, err := regexp.Compile(rule.Regex)
+			if err != nil {
+				return nil, fmt.Errorf("filter rule %v: %v", rule.Name, err)
+			}
+			rule.regex = re
+		}
+		if err := compileConditions(rule.Conditions); err != nil {
+			return nil, fmt.Errorf("filter rule %v: %v", rule.Name, err)
+		}
+		counter := "filter." + rule.Name + ".dropped"
+		if shadow {
+			counter = "filter." + rule.Name + ".shadow_dropped"
+		}
+		rule.dropped = metrics.GetOrRegisterCounter(counter, metrics.DefaultRegistry)
+		f.rules = append(f.rules, &rule)
+	}
+	return f, nil
+}
+
+func (f *filter) process(e *logEvent, emit func(*logEvent)) {
+	for _, rule := range f.rules {
+		if rule.regex != nil && !rule.regex.MatchString(e.raw) {
+			continue
+		}
+		if !matchesAll(rule.Conditions, e) {
+			continue
+		}
+		if rule.Action == filterExclude {
+			rule.dropped.Inc(1)
+			if !f.shadow {
+				e.done()
+				return
+			}
+		}
+		break
+	}
+	emit(e)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/stretchr/testify/assert"
+)
+
+var filterLines = []string{
+	`{"level":"error","MESSAGE":"GET /__health failed"}`,
+	`127.0.0.1 - - [21/Apr/2015:12:15:34 +0000] "GET /__gtg HTTP/1.1" 200 2`,
+	`{"level":"debug","msg":"mapping annotations"}`,
+	`{"level":"info","monitoring_event":"true","SYSTEMD_UNIT":"publish-monitor@1.service"}`,
+	`{"level":"info","monitoring_event":"true","SYSTEMD_UNIT":"annotations-mapper@2.service"}`,
+	`127.0.0.1 - - [21/Apr/2015:12:15:34 +0000] "GET /content HTTP/1.1" 200 53706`,
+}
+
+func filterAll(f *filter, lines []string) []string {
+	kept := []string{}
+	for _, line := range lines {
+		f.process(newLogEvent(line), func(e *logEvent) { kept = append(kept, e.raw) })
+	}
+	return kept
+}
+
+func Test_Filter(t *testing.T) {
+	rules, err := loadFilterRules("testdata/filters.json")
+	assert.NoError(t, err)
+	f, err := newFilter(rules, false)
+	assert.NoError(t, err)
+	healthchecks := metrics.GetOrRegisterCounter("filter.healthchecks.dropped", metrics.DefaultRegistry).Count()
+	debug := metrics.GetOrRegisterCounter("filter.debug.dropped", metrics.DefaultRegistry).Count()
+
+	assert.Equal(t, []string{filterLines[0], filterLines[4], filterLines[5]}, filterAll(f, filterLines))
+	assert.Equal(t, healthchecks+1, metrics.GetOrRegisterCounter("filter.healthchecks.dropped", metrics.DefaultRegistry).Count())
+	assert.Equal(t, debug+1, metrics.GetOrRegisterCounter("filter.debug.dropped", metrics.DefaultRegistry).Count())
+}
+
+func Test_Filter_Shadow(t *testing.T) {
+	rules, err := loadFilterRules("testdata/filters.json")
+	assert.NoError(t, err)
+	f, err := newFilter(rules, true)
+	assert.NoError(t, err)
+	monitoring := metrics.GetOrRegisterCounter("filter.monitoring.shadow_dropped", metrics.DefaultRegistry).Count()
+
+	assert.Equal(t, filterLines, filterAll(f, filterLines))
+	assert.Equal(t, monitoring+1, metrics.GetOrRegisterCounter("filter.monitoring.shadow_dropped", metrics.DefaultRegistry).Count())
+}
+
+func Test_NewFilter_Invalid(t *testing.T) {
+	for _, rule := range []filterRule{
+		{Action: "keep"},
+		{Action: filterExclude, Regex: "("},
+		{Action: filterExclude, Conditions: []routeCondition{{Field: "level", Match: "contains"}}},
+	} {
+		_, err := newFilter([]filterRule{rule}, false)
+		assert.Error(t, err, "%+v", rule)
+	}
+}
@@ -5,26 +5,21 @@ import (
 	"crypto/tls"
 	"encoding/json"
 	"flag"
-	"fmt"
-	"io"
-	"io/ioutil"
 	"log"
 	"net"
 	"net/http"
 	"os"
-	"regexp"
-	"strconv"
 	"strings"
 	"sync"
 	"time"
 
-	"errors"
 	graphite "github.com/cyberdelia/go-metrics-graphite"
 	"github.com/rcrowley/go-metrics"
 )
 
+const stageTickInterval = 100 * time.Millisecond
+
 var (
-	wg              sync.WaitGroup
 	client          *http.Client
 	fwdURL          string
 	env             string
@@ -41,22 +36,19 @@ var (
 	bucket          string
 	awsRegion       string
 	br              *bufio.Reader
-	timerChan       = make(chan bool)
-	timestampRegex  = regexp.MustCompile("([0-9]+)-(0[1-9]|1[012])-(0[1-9]|[12][0-9]|3[01])[Tt]([01][0-9]|2[0-3]):([0-5][0-9]):([0-5][0-9]|60)(.[0-9]+)?(([Zz])|([+|-]([01][0-9]|2[0-3]):[0-5][0-9]))")
-	status          = &serviceStatus{healthy: false, timestamp: time.Now()}
-	logRetry        Retry
-	request_count   metrics.Counter
-	error_count     metrics.Counter
 )
 
 func main() {
-	if len(fwdURL) == 0 { //Check whether -url parameter value was provided
-		log.Printf("-url=http_endpoint parameter must be provided\n")
-		os.Exit(1) //If not fail visibly as we are unable to send logs to Splunk
+	if err := setupConfig(); err != nil {
+		log.Printf("Invalid configuration: %v\n", err)
+		os.Exit(1)
 	}
-	if len(token) == 0 { //Check whether -token parameter value was provided
-		log.Printf("-token=secret must be provided\n")
-		os.Exit(1) //If not fail visibly as we are unable to send logs to Splunk
+	if len(routeSample) > 0 { //Dry run of the routing rules, nothing is forwarded
+		if err := routeDryRun(routeSample, os.Stdout); err != nil {
+			log.Printf("Routing dry run failed: %v\n", err)
+			os.Exit(1)
+		}
+		return
 	}
 	if len(hostname) == 0 { //Check whether -hostname parameter was provided. If not attempt to resolve
 		hname, err := os.Hostname() //host name reported by the kernel, used for graphiteNamespace
@@ -67,14 +59,36 @@ func main() {
 			hostname = hname
 		}
 	}
-	if len(bucket) == 0 { //Check whether -bucket parameter value was provided
-		log.Printf("-bucket=bucket_name\n")
-		os.Exit(1) //If not fail visibly as we are unable to send logs to Splunk
+	if len(embeddedField) > 0 {
+		if err := validateEmbedded(strings.Split(embeddedFormats, ","), embeddedCollision); err != nil {
+			log.Printf("-embeddedField: %v\n", err)
+			os.Exit(1)
+		}
 	}
 
-	log.Printf("Splunk forwarder (workers %v, buffer size %v, batchsize %v, batchtimer %v): Started\n", workers, chanBuffer, batchsize, batchtimer)
+	if err := setupTimestampExtractors(); err != nil {
+		log.Printf("Invalid timestamp configuration: %v\n", err)
+		os.Exit(1)
+	}
+	if err := setupRouter(); err != nil {
+		log.Printf("Invalid routing rules: %v\n", err)
+		os.Exit(1)
+	}
+	if err := setupIndexedFields(); err != nil {
+		log.Printf("Invalid indexed fields: %v\n", err)
+		os.Exit(1)
+	}
+	if err := setupMetricEvents(); err != nil {
+		log.Printf("Invalid metric events configuration: %v\n", err)
+		os.Exit(1)
+	}
+	if err := setupPipelines(); err != nil {
+		log.Printf("Invalid pipelines: %v\n", err)
+		os.Exit(1)
+	}
+
+	log.Printf("Splunk forwarder (pipelines %v, outputs %v): Started\n", len(pipelines), len(outputs))
 	defer log.Printf("Splunk forwarder: Stopped\n")
-	logChan := make(chan string, chanBuffer)
 
 	graphiteNamespace := strings.Join([]string{graphitePrefix, env, graphitePostfix, hostname}, ".") // graphiteNamespace ~ prefix.env.postfix.hostname
 	log.Printf("%v namespace: %v\n", graphiteServer, graphiteNamespace)
@@ -88,188 +102,74 @@ func main() {
 		go graphite.Graphite(metrics.DefaultRegistry, 5*time.Second, graphiteNamespace, addr)
 	}
 	go metrics.Log(metrics.DefaultRegistry, 5*time.Second, log.New(os.Stdout, "metrics ", log.Lmicroseconds))
-	go queueLenMetrics(logChan)
-	splunkMetrics()
 
-	for i := 0; i < workers; i++ {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			for msg := range logChan {
-				if dryrun {
-					log.Printf("Dryrun enabled, not posting to %v\n", fwdURL)
-				} else {
-					postToSplunk(msg)
-				}
-			}
-		}()
+	for _, out := range outputs {
+		log.Printf("Output %v (workers %v, batchsize %v, batchtimer %v): Started\n", out.name, out.workers, out.batchsize, out.batchtimer)
+		out.start()
 	}
-
-	if br == nil {
-		br = bufio.NewReader(os.Stdin)
+	var running sync.WaitGroup
+	for _, p := range pipelines {
+		running.Add(1)
+		go func(p *eventPipeline) {
+			defer running.Done()
+			p.run()
+		}(p)
 	}
-	i := 0
-	eventlist := make([]string, batchsize) //create eventlist slice that is size of -batchsize
-	timerd := time.Duration(batchtimer) * time.Second
-	timer := time.NewTimer(timerd) //create timer object with duration specified by -batchtimer
-	go func() {                    //Create go routine for timer that writes into timerChan when it expires
-		for {
-			<-timer.C
-			timerChan <- true
-		}
+	done := make(chan bool)
+	go func() {
+		running.Wait()
+		close(done)
 	}()
-
-	logRetry = NewRetry(postToSplunk, isHealthy, bucket, awsRegion)
-	logRetry.Start()
-
-	for {
-		//1. Check whether timer has expired or batchsize exceeded before processing new string
-		select { //set i equal to batchsize to trigger delivery if timer expires prior to batchsize limit is exceeded
-		case <-timerChan:
-			log.Println("Timer expired. Trigger delivery to Splunk")
-			eventlist = stripEmptyStrings(eventlist) //remove empty values from slice before writing to channel
-			i = batchsize
-		default:
-			break
-		}
-		if i >= batchsize { //Trigger delivery if batchsize is exceeded
-			writeToLogChan(eventlist, logChan)
-			i = 0 //reset i once batchsize is reached
-			eventlist = nil
-			eventlist = make([]string, batchsize)
-			timer.Reset(timerd) //Reset timer after message delivery
-		}
-		//2. Process new string after ensuring eventlist has sufficient space
-		str, err := br.ReadString('\n')
-		if err != nil {
-			if err == io.EOF { //Shutdown procedures: process eventlist, close channel and workers
-				eventlist = stripEmptyStrings(eventlist) //remove empty values from slice before writing to channel
-				if len(eventlist) > 0 {
-					log.Printf("Processing %v batched messages before exit", len(eventlist))
-					writeToLogChan(eventlist, logChan)
-				}
-				close(logChan)
-				log.Printf("Waiting buffered channel consumer to finish processing messages\n")
-				wg.Wait()
-				return
-			}
-			log.Fatal(err)
-		}
-
-		//3. Append event on eventlist
-		if i != batchsize {
-			eventlist[i] = str
-			i++
-		}
+	var reloads <-chan bool
+	if configFile != "" {
+		reloads = watchConfig(configFile)
 	}
-}
-
-func splunkMetrics() {
-	request_count = metrics.GetOrRegisterCounter("splunk_requests_total", metrics.DefaultRegistry)
-	error_count = metrics.GetOrRegisterCounter("splunk_requests_error", metrics.DefaultRegistry)
-}
 
-func queueLenMetrics(queue chan string) {
-	s := metrics.NewExpDecaySample(1024, 0.015)
-	h := metrics.GetOrRegisterHistogram("post.queue.length", metrics.DefaultRegistry, s)
 	for {
-		time.Sleep(200 * time.Millisecond)
-		h.Update(int64(len(queue)))
-	}
-}
-
-func postToSplunk(s string) error {
-	t := metrics.GetOrRegisterTimer("post.time", metrics.DefaultRegistry)
-	var err error
-	t.Time(func() {
-		req, err := http.NewRequest("POST", fwdURL, strings.NewReader(s))
-		if err != nil {
-			log.Println(err)
-		}
-		tokenWithKeyword := strings.Join([]string{"Splunk", token}, " ") //join strings "Splunk" and value of -token argument
-		req.Header.Set("Authorization", tokenWithKeyword)
-		request_count.Inc(1)
-		r, err := client.Do(req)
-		timestamp := time.Now()
-		if err != nil {
-			error_count.Inc(1)
-			log.Println(err)
-			cacheForRetry(s)
-			status.setHealthy(false, timestamp)
-		} else {
-			defer r.Body.Close()
-			io.Copy(ioutil.Discard, r.Body)
-			if r.StatusCode != 200 {
-				err = errors.New(r.Status)
-				error_count.Inc(1)
-				status.setHealthy(false, timestamp)
-				log.Printf("Unexpected status code %v (%v) when sending %v to %v\n", r.StatusCode, r.Status, s, fwdURL)
-				cacheForRetry(s)
-			} else {
-				status.setHealthy(true, timestamp)
+		select {
+		case <-reloads: //rebuild the processing stages of every pipeline
+			stageFlagsMu.Lock()
+			err := reloadConfig()
+			stageFlagsMu.Unlock()
+			if err != nil {
+				log.Printf("Config reload failed, keeping the current configuration: %v\n", err)
+				break
+			}
+			for _, p := range pipelines {
+				p.reload()
+			}
+			log.Printf("Config reloaded from %v\n", configFile)
+		case <-done: //Shutdown procedures once all pipelines are done: wait for the outputs to deliver their batches
+			log.Printf("Waiting buffered channel consumers to finish processing messages\n")
+			for _, out := range outputs {
+				out.stop()
 			}
+			saveCheckpoints(inputs)
+			return
 		}
-	})
-	return err
-}
-
-func cacheForRetry(s string) {
-	err := logRetry.Enqueue(s)
-	if err != nil {
-		log.Printf("Unexpected error when caching failed messages: %v\n", err)
 	}
 }
 
-func isHealthy() *serviceStatus {
-	return status
-}
-
-func stripEmptyStrings(eventlist []string) []string {
-	//Find empty values in slice. Using map remove empties and return a slice without empty values
-	i := 0
-	map1 := make(map[int]string)
-	for _, v := range eventlist {
-		if v != "" {
-			map1[i] = v
-			i++
-		}
-	}
-	mapToSlice := make([]string, len(map1))
-	i = 0
-	for _, v := range map1 {
-		mapToSlice[i] = v
-		i++
+func writeJSON(eventlist []string) string {
+	events := make([]*logEvent, 0, len(eventlist))
+	for _, e := range eventlist {
+		events = append(events, newLogEvent(e))
 	}
-	return mapToSlice
+	return writeEvents(events)
 }
 
-func writeJSON(eventlist []string) string {
+func writeEvents(eventlist []*logEvent) string {
 	//Function produces Splunk HEC compatible json document for batched events
 	// Example: { "event": "event 1"} { "event": "event 2"}
 	var jsonDoc string
 
 	for _, e := range eventlist {
-		timestamp := timestampRegex.FindStringSubmatch(e)
-
-		var err error
-		var t = time.Now()
-		if len(timestamp) > 0 {
-			t, err = time.Parse(time.RFC3339Nano, timestamp[0])
-			if err != nil {
-				t = time.Now()
-			}
-		}
-
-		// For Splunk HEC, the default time format is epoch time format, in the format <sec>.<ms>.
-		// For example, 1433188255.500 indicates 1433188255 seconds and 500 milliseconds after epoch, or Monday, June 1, 2015, at 7:50:55 PM GMT.
-		epochMillis, err := strconv.ParseFloat(fmt.Sprintf("%d.%03d", t.Unix(), t.Nanosecond()/int(time.Millisecond)), 64)
-		if err != nil {
-			epochMillis = float64(t.UnixNano()) / float64(time.Second)
-		}
-		item := map[string]interface{}{"event": e, "time": epochMillis}
+		e.decorate()
+		item := e.hec()
 		jsonItem, err := json.Marshal(&item)
 		if err != nil {
-			jsonDoc = strings.Join([]string{jsonDoc, strings.Join([]string{"{ \"event\":", e, "}"}, "")}, " ")
+			body, _ := json.Marshal(e.rawBody()) // the body of the event as a string, redacted if its fields were
+			jsonDoc = strings.Join([]string{jsonDoc, strings.Join([]string{"{ \"event\":", string(body), "}"}, "")}, " ")
 		} else {
 			jsonDoc = strings.Join([]string{jsonDoc, string(jsonItem)}, " ")
 		}
@@ -277,14 +177,22 @@ func writeJSON(eventlist []string) string {
 	return jsonDoc
 }
 
-func writeToLogChan(eventlist []string, logChan chan string) {
-	if len(eventlist) > 0 { //only attempt delivery if eventlist contains elements
-		jsonSTRING := writeJSON(eventlist)
-		t := metrics.GetOrRegisterTimer("post.queue.latency", metrics.DefaultRegistry)
-		t.Time(func() {
-			//log.Printf("Sending document to channel: %v", jsonSTRING)
-			logChan <- jsonSTRING
-		})
+func writePayload(eventlist []*logEvent, mode string) string {
+	if mode == hecModeRaw {
+		return writeRaw(eventlist)
+	}
+	return writeEvents(eventlist)
+}
+
+// batch is a payload posted to Splunk together with the acknowledgements of its events
+type batch struct {
+	payload string
+	acks    []func()
+}
+
+func (b batch) ack() {
+	for _, ack := range b.acks {
+		ack()
 	}
 }
 
@@ -308,6 +216,82 @@ func init() {
 	flag.IntVar(&batchtimer, "batchtimer", 5, "Expiry in seconds after which delivering events to Splunk HEC")
 	flag.StringVar(&bucket, "bucketName", "", "S3 bucket for caching failed events")
 	flag.StringVar(&awsRegion, "awsRegion", "", "AWS region for S3")
+	flag.BoolVar(&journald, "journald", false, "Parse events as journalctl json output and map its fields onto HEC host, source, sourcetype and time")
+	flag.StringVar(&sourcetypeField, "sourcetypeField", "", "Journald field used as HEC sourcetype")
+	flag.BoolVar(&stripJournaldFields, "stripJournaldFields", false, "Remove the journald fields mapped onto HEC metadata from the event body")
+	flag.StringVar(&embeddedField, "embeddedField", "", "Event field holding an embedded payload to decode and merge into the event, e.g. MESSAGE")
+	flag.StringVar(&embeddedFormats, "embeddedFormats", "json,logfmt", "Comma separated formats tried in order when decoding -embeddedField")
+	flag.StringVar(&embeddedCollision, "embeddedCollision", collisionKeep, "How decoded keys clashing with existing fields are merged: keep, overwrite or prefix")
+	flag.StringVar(&timestampFile, "timestamps", "", "Json file with the ordered list of rules used to extract event timestamps")
+	flag.StringVar(&timezone, "timezone", "UTC", "Timezone of extracted timestamps which do not specify one")
+	flag.StringVar(&routesFile, "routes", "", "Json file with the ordered rules setting HEC index, sourcetype, source and host")
+	flag.StringVar(&routeSample, "routeSample", "", "Print the route matched by the given sample event and exit")
+	flag.StringVar(&staticFieldsFlag, "fields", "", "Comma separated key=value HEC indexed fields added to every event. Values may refer to ${env} and ${hostname}")
+	flag.StringVar(&liftFieldsFlag, "liftFields", "", "Comma separated event fields copied into HEC indexed fields, e.g. platform,service_name,transaction_id")
+	flag.IntVar(&maxFieldLength, "maxFieldLength", 1024, "Maximum length in bytes of an indexed field value, longer values are truncated")
+	flag.StringVar(&hecMode, "hecMode", hecModeEvent, "HEC endpoint to send to: event, or raw to let Splunk break lines and extract timestamps")
+	flag.StringVar(&channel, "channel", "", "HEC channel used in raw mode. A random one is generated if empty")
+	flag.StringVar(&rawSourcetype, "rawSourcetype", "", "Sourcetype of the batches sent in raw mode")
+	flag.StringVar(&rawIndex, "rawIndex", "", "Index of the batches sent in raw mode")
+	flag.StringVar(&outputType, "outputType", outputSplunk, "Output the batches are sent to: splunk HEC, or opensearch to index them with the bulk API of OpenSearch or Elasticsearch")
+	flag.StringVar(&indexTemplate, "indexTemplate", "logs-{2006.01.02}", "Index of the documents sent to opensearch. Go time layouts in braces are replaced by the event time in UTC")
+	flag.StringVar(&metricFormatsFlag, "metricFormats", "", "Comma separated formats of metric lines sent as HEC metric events: statsd, json")
+	flag.StringVar(&metricField, "metricField", "", "Event field holding the metric line, e.g. MESSAGE. The whole event is used if empty")
+	flag.StringVar(&metricsIndex, "metricsIndex", "", "Splunk metrics index receiving the metric events")
+	flag.StringVar(&metricDimensionsFlag, "metricDimensions", "", "Comma separated event fields added as dimensions to metric events, e.g. SYSTEMD_UNIT,HOSTNAME")
+	flag.StringVar(&redactBuiltins, "redact", "", "Comma separated builtin redaction rules masking matches: email, card, bearer, authorization")
+	flag.StringVar(&redactRulesFile, "redactRules", "", "Json file with redaction rules")
+	flag.StringVar(&redactKey, "redactKey", "", "Secret key of the HMAC used by the hash redaction action")
+	flag.StringVar(&filterRulesFile, "filterRules", "", "Json file with the ordered rules including or excluding events before batching")
+	flag.BoolVar(&filterShadow, "filterShadow", false, "Only count the events the filter rules would drop, without dropping them")
+	flag.StringVar(&sampleRulesFile, "sampleRules", "", "Json file with the ordered rules keeping a fraction of the matching events")
+	flag.StringVar(&rateLimitField, "rateLimitField", "SYSTEMD_UNIT", "Event field identifying the source of an event for rate limiting")
+	flag.Float64Var(&rateLimit, "rateLimit", 0, "Events per second allowed from a single source. 0 disables rate limiting")
+	flag.IntVar(&rateLimitBurst, "rateLimitBurst", 1000, "Events a source can send at once before being rate limited")
+	flag.StringVar(&rateLimitAction, "rateLimitAction", rateLimitSummarise, "What happens to events over the rate limit: drop, sample or summarise")
+	flag.Float64Var(&rateLimitSampleRate, "rateLimitSampleRate", 0.01, "Fraction of the events over the rate limit kept by the sample action")
+	flag.IntVar(&rateLimitSummary, "rateLimitSummary", 60, "Interval in seconds between the events summarising suppressed events")
+	flag.StringVar(&multilineStart, "multilineStart", "", "Regex matching the first line of a multiline event")
+	flag.StringVar(&multilineContinue, "multilineContinue", "", "Regex matching the lines continuing a multiline event, e.g. ^\\s+at ")
+	flag.StringVar(&multilineField, "multilineField", "", "Event field holding the line text, e.g. MESSAGE. The whole event is used if empty")
+	flag.StringVar(&multilineKey, "multilineKey", "", "Event field identifying the source whose lines are joined. The HEC source is used if empty")
+	flag.IntVar(&multilineMaxLines, "multilineMaxLines", 500, "Maximum number of lines joined into one event")
+	flag.IntVar(&multilineMaxBytes, "multilineMaxBytes", 65536, "Maximum size in bytes of a multiline event")
+	flag.IntVar(&multilineTimeoutMs, "multilineTimeout", 1000, "Milliseconds after which a multiline event which is not continued is sent")
+	flag.BoolVar(&dedup, "dedup", false, "Drop events already seen within the dedup window")
+	flag.StringVar(&dedupField, "dedupField", "__CURSOR", "Event field identifying an event for dedup. A hash of the event is used if it is missing")
+	flag.IntVar(&dedupWindow, "dedupWindow", 600, "Seconds during which an event is remembered for dedup")
+	flag.IntVar(&dedupSize, "dedupSize", 100000, "Maximum number of events remembered for dedup")
+	flag.StringVar(&stateDir, "stateDir", "", "Directory keeping state across restarts, e.g. the dedup cache. State is not kept if empty")
+	flag.StringVar(&enrichFlag, "enrich", "", "Comma separated key=value fields added to every event. Values may refer to ${env} and ${hostname}")
+	flag.BoolVar(&enrichHostFacts, "enrichHostFacts", false, "Add the hostname and env fields to every event")
+	flag.StringVar(&metadataProvider, "metadataProvider", "", "Provider of the instance metadata added to every event: file")
+	flag.StringVar(&metadataFile, "metadataFile", "", "Json object with the instance metadata read by the file metadata provider")
+	flag.StringVar(&lookupTablesFlag, "lookupTables", "", "Comma separated field=path csv or json tables of fields added to events by the value of field, e.g. SYSTEMD_UNIT=teams.csv")
+	flag.StringVar(&configFile, "config", "", "Json config file with inputs, stages, outputs, retry and metrics sections. Flags and FORWARDER_* environment variables override it")
+	flag.StringVar(&pipelinesFile, "pipelines", "", "Json file of named outputs and of the pipelines reading inputs through their own stages into them. The flags configure a single pipeline if empty")
+	flag.IntVar(&inputBuffer, "inputBuffer", 256, "Number of events each input buffers ahead of its pipeline")
+	flag.StringVar(&inputsFlag, "inputs", "stdin", "Comma separated inputs read by the forwarder: stdin, syslog, hec, file, journal, kubernetes, socket, fifo. The forwarder stops once all of them are done")
+	flag.StringVar(&syslogUDP, "syslogUDP", "", "Address the syslog input listens on for UDP messages, e.g. :514")
+	flag.StringVar(&syslogTCP, "syslogTCP", "", "Address the syslog input listens on for TCP messages, e.g. :514")
+	flag.StringVar(&syslogSourcetype, "syslogSourcetype", "syslog", "Sourcetype of the events received by the syslog input")
+	flag.StringVar(&hecListen, "hecListen", ":8088", "Address the hec input listens on for HEC requests")
+	flag.StringVar(&hecTokensFlag, "hecTokens", "", "Comma separated tokens accepted by the hec input")
+	flag.IntVar(&hecQueueLength, "hecQueue", 10000, "Number of events the hec input queues before answering 503")
+	flag.StringVar(&filePatterns, "files", "", "Comma separated glob patterns of the files tailed by the file input, e.g. /var/log/app/*.log")
+	flag.StringVar(&journalCommand, "journalCommand", "journalctl", "Command run by the journal input to follow the journal")
+	flag.StringVar(&journalFile, "journalFile", "", "Recorded journal export file read by the journal input instead of following the journal")
+	flag.StringVar(&journalUnits, "journalUnits", "", "Comma separated units, or glob patterns of units, read by the journal input. All units if empty")
+	flag.StringVar(&journalPriority, "journalPriority", "", "Lowest priority read by the journal input, e.g. warning or 4. All priorities if empty")
+	flag.StringVar(&kubernetesLogs, "kubernetesLogs", "/var/log/containers/*.log", "Comma separated glob patterns of the container logs tailed by the kubernetes input")
+	flag.StringVar(&kubernetesMetadata, "kubernetesMetadata", "", "Json file of pod metadata by <namespace>/<pod>, added to the events of the kubernetes input as indexed fields. Reloaded when it changes")
+	flag.StringVar(&framing, "framing", framingNewline, "Framing of the messages read from stdin, stream sockets and fifos: newline, nul, length (32 bit big endian prefix) or json (concatenated objects)")
+	flag.IntVar(&maxMessageBytes, "maxMessageBytes", 1024*1024, "Size in bytes above which messages read from stdin, stream sockets and fifos are truncated")
+	flag.StringVar(&truncatedField, "truncatedField", "truncated", "Indexed field set to true on truncated messages. None if empty")
+	flag.StringVar(&socketPath, "socketPath", "", "Path of the Unix socket the socket input listens on, e.g. /var/run/forwarder.sock")
+	flag.StringVar(&socketType, "socketType", "stream", "Type of the Unix socket of the socket input: stream for messages framed by -framing, or datagram for a message per datagram")
+	flag.StringVar(&socketMode, "socketMode", "0660", "Octal permissions of the Unix socket of the socket input")
+	flag.StringVar(&fifoPath, "fifoPath", "", "Path of the named pipe read by the fifo input, created if it does not exist")
 
 	flag.Parse()
 }
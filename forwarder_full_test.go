@@ -56,12 +56,16 @@ type s3ServiceMock struct {
 var splunk = splunkMock{}
 
 func (s3 *s3ServiceMock) ListAndDelete() ([]string, error) {
+	s3.Lock()
+	defer s3.Unlock()
 	items := s3.cache
 	s3.cache = make([]string, 0)
 	return items, nil
 }
 
 func (s3 *s3ServiceMock) Put(obj string) error {
+	s3.Lock()
+	defer s3.Unlock()
 	obj = strings.Replace(obj, "error", "retry", -1)
 	s3.cache = append(s3.cache, obj)
 	return nil
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+const (
+	framingNewline = "newline"
+	framingNUL     = "nul"
+	framingLength  = "length"
+	framingJSON    = "json"
+)
+
+var (
+	framing         string
+	maxMessageBytes int
+	truncatedField  string
+
+	oversizedMessages = metrics.GetOrRegisterCounter("framing.oversized", metrics.DefaultRegistry)
+)
+
+// framer splits a stream into messages of at most max bytes. Longer messages are truncated to max bytes, the rest of
+// them being skipped, so that a single huge message can not exhaust memory.
+type framer interface {
+	// next returns the next message and whether it was truncated, with io.EOF once the stream is exhausted
+	next() (msg string, truncated bool, err error)
+}
+
+func checkFraming(mode string, max int) error {
+	if max < 1 {
+		return fmt.Errorf("max message bytes %v must be positive", max)
+	}
+	switch mode {
+	case framingNewline, framingNUL, framingLength, framingJSON:
+		return nil
+	}
+	return fmt.Errorf("unknown framing %q, expected newline, nul, length or json", mode)
+}
+
+func newFramer(r io.Reader, mode string, max int) (framer, error) {
+	if err := checkFraming(mode, max); err != nil {
+		return nil, err
+	}
+	br := bufio.NewReader(r)
+	switch mode {
+	case framingNewline:
+		return &delimitedFramer{br, '\n', max, true}, nil
+	case framingNUL:
+		return &delimitedFramer{br, 0, max, false}, nil
+	case framingLength:
+		return &lengthFramer{br, max}, nil
+	}
+	return &jsonFramer{br, max}, nil
+}
+
+// delimitedFramer reads messages terminated by a delimiter. Newline terminated messages keep their newline like the
+// lines read from stdin always did. A last message without delimiter is returned at the end of the stream.
+type delimitedFramer struct {
+	r         *bufio.Reader
+	delimiter byte
+	max       int
+	keep      bool
+}
+
+func (f *delimitedFramer) next() (string, bool, error) {
+	msg := []byte{}
+	truncated := false
+	for {
+		chunk, err := f.r.ReadSlice(f.delimiter)
+		if err == bufio.ErrBufferFull {
+			msg, truncated = appendBounded(msg, chunk, f.max, truncated)
+			continue
+		}
+		if err != nil {
+			msg, truncated = appendBounded(msg, chunk, f.max, truncated)
+			if len(msg) > 0 && err == io.EOF {
+				return string(msg), truncated, nil
+			}
+			return "", false, err
+		}
+		// the delimiter does not count towards the length
+		msg, truncated = appendBounded(msg, chunk[:len(chunk)-1], f.max, truncated)
+		if f.keep {
+			msg = append(msg, f.delimiter)
+		}
+		return string(msg), truncated, nil
+	}
+}
+
+// lengthFramer reads messages prefixed by their length as a 32 bit big endian integer
+type lengthFramer struct {
+	r   *bufio.Reader
+	max int
+}
+
+func (f *lengthFramer) next() (string, bool, error) {
+	var length uint32
+	if err := binary.Read(f.r, binary.BigEndian, &length); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return "", false, fmt.Errorf("incomplete message length")
+		}
+		return "", false, err
+	}
+	size := int64(length)
+	if size > int64(f.max) {
+		size = int64(f.max)
+	}
+	msg := make([]byte, size)
+	if _, err := io.ReadFull(f.r, msg); err != nil {
+		return "", false, unexpectedEOF(err)
+	}
+	if rest := int64(length) - size; rest > 0 {
+		if _, err := io.CopyN(ioutil.Discard, f.r, rest); err != nil {
+			return "", false, unexpectedEOF(err)
+		}
+		return string(msg), true, nil
+	}
+	return string(msg), false, nil
+}
+
+// jsonFramer reads concatenated json objects or arrays, optionally separated by whitespace, e.g. {"a":1}{"a":2}. Other
+// top level values are read up to the next whitespace.
+type jsonFramer struct {
+	r   *bufio.Reader
+	max int
+}
+
+func (f *jsonFramer) next() (string, bool, error) {
+	c, err := f.skipSpace()
+	if err != nil {
+		return "", false, err
+	}
+	msg := []byte{c}
+	truncated := false
+	add := func(c byte) {
+		if len(msg) < f.max {
+			msg = append(msg, c)
+		} else {
+			truncated = true
+		}
+	}
+	if c != '{' && c != '[' {
+		for {
+			c, err := f.r.ReadByte()
+			if err == io.EOF || (err == nil && isJSONSpace(c)) {
+				return string(msg), truncated, nil
+			}
+			if err != nil {
+				return "", false, err
+			}
+			add(c)
+		}
+	}
+	depth, inString, escaped := 1, false, false
+	for depth > 0 {
+		c, err := f.r.ReadByte()
+		if err != nil {
+			return "", false, unexpectedEOF(err)
+		}
+		add(c)
+		switch {
+		case escaped:
+			escaped = false
+		case inString:
+			escaped = c == '\\'
+			inString = c != '"'
+		case c == '"':
+			inString = true
+		case c == '{' || c == '[':
+			depth++
+		case c == '}' || c == ']':
+			depth--
+		}
+	}
+	return string(msg), truncated, nil
+}
+
+func (f *jsonFramer) skipSpace() (byte, error) {
+	for {
+		c, err := f.r.ReadByte()
+		if err != nil || !isJSONSpace(c) {
+			return c, err
+		}
+	}
+}
+
+func isJSONSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+// appendBounded appends as much of chunk as fits in max bytes, reporting whether anything was left out
+func appendBounded(msg, chunk []byte, max int, truncated bool) ([]byte, bool) {
+	if room := max - len(msg); len(chunk) > room {
+		return append(msg, chunk[:room]...), true
+	}
+	return append(msg, chunk...), truncated
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// readFrames sends the messages of a stream as events until it is exhausted. Empty messages, e.g. between consecutive
+// delimiters, are skipped as HEC rejects blank events. Truncated messages are counted and marked by the -truncatedField
+// indexed field.
+func readFrames(r io.Reader, send func(*logEvent)) error {
+	f, err := newFramer(r, framing, maxMessageBytes)
+	if err != nil {
+		return err
+	}
+	for {
+		msg, truncated, err := f.next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if msg == "" || msg == "\n" {
+			continue
+		}
+		e := newLogEvent(msg)
+		if truncated {
+			markTruncated(e)
+		}
+		send(e)
+	}
+}
+
+// markTruncated counts an event truncated to -maxMessageBytes and marks it by the -truncatedField indexed field
+func markTruncated(e *logEvent) {
+	oversizedMessages.Inc(1)
+	if truncatedField != "" {
+		if e.indexed == nil {
+			e.indexed = map[string]interface{}{}
+		}
+		e.indexed[truncatedField] = "true"
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type frame struct {
+	msg       string
+	truncated bool
+}
+
+func readAllFrames(t *testing.T, r io.Reader, mode string, max int) []frame {
+	f, err := newFramer(r, mode, max)
+	assert.NoError(t, err)
+	frames := []frame{}
+	for {
+		msg, truncated, err := f.next()
+		if err == io.EOF {
+			return frames
+		}
+		if !assert.NoError(t, err) {
+			return frames
+		}
+		frames = append(frames, frame{msg, truncated})
+	}
+}
+
+func Test_Framer_Newline(t *testing.T) {
+	long := strings.Repeat("x", 10000)
+	frames := readAllFrames(t, strings.NewReader("short\n12345\n"+long+"\nlast"), framingNewline, 5)
+	assert.Equal(t, []frame{{"short\n", false}, {"12345\n", false}, {"xxxxx\n", true}, {"last", false}}, frames)
+}
+
+func Test_Framer_NUL(t *testing.T) {
+	frames := readAllFrames(t, strings.NewReader("first\nline\x00second\x00toolong\x00"), framingNUL, 6)
+	assert.Equal(t, []frame{{"first\n", true}, {"second", false}, {"toolon", true}}, frames)
+}
+
+func Test_Framer_Length(t *testing.T) {
+	buf := &bytes.Buffer{}
+	for _, msg := range []string{"one", "", "with\nnewline", "much too long"} {
+		binary.Write(buf, binary.BigEndian, uint32(len(msg)))
+		buf.WriteString(msg)
+	}
+	frames := readAllFrames(t, buf, framingLength, 12)
+	assert.Equal(t, []frame{{"one", false}, {"", false}, {"with\nnewline", false}, {"much too lon", true}}, frames)
+
+	f, _ := newFramer(bytes.NewReader([]byte{0, 0, 0, 9, 'a'}), framingLength, 100)
+	_, _, err := f.next()
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+	f, _ = newFramer(bytes.NewReader([]byte{0, 0}), framingLength, 100)
+	_, _, err = f.next()
+	assert.Error(t, err)
+	assert.NotEqual(t, io.EOF, err)
+}
+
+func Test_Framer_JSON(t *testing.T) {
+	input := `{"a":1}{"b":"}{\"["}` + "\n" + `[1,{"c":[2]}]  {"long":"abcdefghijklmnopqrstuvwxyz"}{"d":4} 42`
+	frames := readAllFrames(t, strings.NewReader(input), framingJSON, 20)
+	assert.Equal(t, []frame{{`{"a":1}`, false}, {`{"b":"}{\"["}`, false}, {`[1,{"c":[2]}]`, false},
+		{`{"long":"abcdefghijk`, true}, {`{"d":4}`, false}, {`42`, false}}, frames)
+
+	f, _ := newFramer(strings.NewReader(`{"a":`), framingJSON, 100)
+	_, _, err := f.next()
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+}
+
+func Test_CheckFraming(t *testing.T) {
+	assert.NoError(t, checkFraming(framingJSON, 1))
+	assert.Error(t, checkFraming("csv", 1))
+	assert.Error(t, checkFraming(framingNewline, 0))
+}
+
+func withFraming(mode string, max int) func() {
+	previousMode, previousMax := framing, maxMessageBytes
+	framing, maxMessageBytes = mode, max
+	return func() {
+		framing, maxMessageBytes = previousMode, previousMax
+	}
+}
+
+func Test_ReadFrames_MarksTruncatedEvents(t *testing.T) {
+	defer withFraming(framingNewline, 4)()
+	oversized := oversizedMessages.Count()
+	events := []*logEvent{}
+	assert.NoError(t, readFrames(strings.NewReader("abc\nabcdef\n"), func(e *logEvent) { events = append(events, e) }))
+	assert.Equal(t, []string{"abc\n", "abcd\n"}, raws(events))
+	assert.Nil(t, events[0].indexed)
+	assert.Equal(t, "true", events[1].indexed["truncated"])
+	assert.Equal(t, oversized+1, oversizedMessages.Count())
+}
+
+func Test_ReadFrames_SkipsEmptyMessages(t *testing.T) {
+	for mode, stream := range map[string]string{
+		framingNUL:     "a\x00\x00b\x00",
+		framingNewline: "a\n\nb\n",
+		framingLength:  "\x00\x00\x00\x01a\x00\x00\x00\x00\x00\x00\x00\x01b",
+	} {
+		defer withFraming(mode, 1024)()
+		events := []*logEvent{}
+		assert.NoError(t, readFrames(strings.NewReader(stream), func(e *logEvent) { events = append(events, e) }), mode)
+		assert.Len(t, events, 2, mode)
+	}
+}
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+const (
+	// hecMaxBody bounds the size of a request body, once decompressed
+	hecMaxBody = 16 * 1024 * 1024
+	// hecRetryAfter is the number of seconds clients are asked to wait when the queue is full
+	hecRetryAfter = 5
+)
+
+var (
+	hecListen      string
+	hecTokensFlag  string
+	hecQueueLength int
+)
+
+// hecResponse is the json body of the responses of the Splunk HEC endpoints, see
+// https://docs.splunk.com/Documentation/Splunk/latest/Data/TroubleshootHTTPEventCollector
+type hecResponse struct {
+	Text string `json:"text"`
+	Code int    `json:"code"`
+}
+
+var (
+	hecSuccess      = hecResponse{"Success", 0}
+	hecTokenMissing = hecResponse{"Token is required", 2}
+	hecTokenInvalid = hecResponse{"Invalid token", 4}
+	hecNoData       = hecResponse{"No data", 5}
+	hecInvalidData  = hecResponse{"Invalid data format", 6}
+	hecServerBusy   = hecResponse{"Server is busy", 9}
+	hecTooLarge     = hecResponse{"Request entity too large", 6}
+	hecNoEvent      = hecResponse{"Event field is required", 12}
+	hecHealthy      = hecResponse{"HEC is healthy", 17}
+)
+
+// hecRequestEvent is an event posted to the HEC event endpoint
+type hecRequestEvent struct {
+	Event      interface{}            `json:"event"`
+	Time       interface{}            `json:"time"`
+	Host       string                 `json:"host"`
+	Source     string                 `json:"source"`
+	Sourcetype string                 `json:"sourcetype"`
+	Index      string                 `json:"index"`
+	Fields     map[string]interface{} `json:"fields"`
+}
+
+// errBodyTooLarge is returned reading a request body longer than hecMaxBody
+var errBodyTooLarge = errors.New("request body too large")
+
+// hecInput accepts the requests of HEC clients, e.g. apps and other agents, on the event and raw endpoints. Requests are
+// accepted or rejected as a whole: when their events do not fit in the queue, 503 is returned with Retry-After, and 413
+// when they would never fit.
+type hecInput struct {
+	listener     net.Listener
+	tokens       map[string]bool
+	queue        chan *logEvent
+	enqueue      sync.Mutex
+	received     metrics.Counter
+	rejected     metrics.Counter
+	unauthorised metrics.Counter
+}
+
+func newHECInput(addr string, tokens []string, queueLength int) (*hecInput, error) {
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("hec input requires -hecTokens")
+	}
+	if queueLength < 1 {
+		return nil, fmt.Errorf("hec input queue length %v must be positive", queueLength)
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	h := &hecInput{
+		listener:     l,
+		tokens:       map[string]bool{},
+		queue:        make(chan *logEvent, queueLength),
+		received:     metrics.GetOrRegisterCounter("hec_input.received", metrics.DefaultRegistry),
+		rejected:     metrics.GetOrRegisterCounter("hec_input.rejected", metrics.DefaultRegistry),
+		unauthorised: metrics.GetOrRegisterCounter("hec_input.unauthorised", metrics.DefaultRegistry),
+	}
+	for _, token := range tokens {
+		h.tokens[token] = true
+	}
+	return h, nil
+}
+
+func (h *hecInput) run(events chan<- *logEvent) error {
+	go func() {
+		for e := range h.queue {
+			events <- e
+		}
+	}()
+	return http.Serve(h.listener, h.handler())
+}
+
+func (h *hecInput) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/services/collector", h.authorised(h.handleEvents))
+	mux.HandleFunc("/services/collector/event", h.authorised(h.handleEvents))
+	mux.HandleFunc("/services/collector/event/1.0", h.authorised(h.handleEvents))
+	mux.HandleFunc("/services/collector/raw", h.authorised(h.handleRaw))
+	mux.HandleFunc("/services/collector/raw/1.0", h.authorised(h.handleRaw))
+	mux.HandleFunc("/services/collector/health", h.handleHealth)
+	return mux
+}
+
+func (h *hecInput) authorised(handle http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			w.Header().Set("Allow", "POST")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		auth := r.Header.Get("Authorization")
+		if auth == "" {
+			h.unauthorised.Inc(1)
+			respond(w, http.StatusUnauthorized, hecTokenMissing)
+			return
+		}
+		if fields := strings.Fields(auth); len(fields) != 2 || fields[0] != "Splunk" || !h.tokens[fields[1]] {
+			h.unauthorised.Inc(1)
+			respond(w, http.StatusForbidden, hecTokenInvalid)
+			return
+		}
+		handle(w, r)
+	}
+}
+
+func (h *hecInput) handleEvents(w http.ResponseWriter, r *http.Request) {
+	body, err := requestBody(r)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	d := json.NewDecoder(body)
+	d.UseNumber()
+	events := []*logEvent{}
+	for {
+		item := hecRequestEvent{}
+		if err := d.Decode(&item); err == io.EOF {
+			break
+		} else if err != nil {
+			respondError(w, err)
+			return
+		}
+		if item.Event == nil || item.Event == "" {
+			respond(w, http.StatusBadRequest, hecNoEvent)
+			return
+		}
+		e, err := newHECRequestEvent(item)
+		if err != nil {
+			respond(w, http.StatusBadRequest, hecInvalidData)
+			return
+		}
+		events = append(events, e)
+	}
+	h.accept(w, events)
+}
+
+func (h *hecInput) handleRaw(w http.ResponseWriter, r *http.Request) {
+	body, err := requestBody(r)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	query := r.URL.Query()
+	events := []*logEvent{}
+	lines := &delimitedFramer{bufio.NewReader(body), '\n', maxMessageBytes, true}
+	for {
+		line, truncated, err := lines.next()
+		if strings.TrimSpace(line) != "" {
+			e := newLogEvent(line)
+			overrideMetadata(e, query.Get("host"), query.Get("source"), query.Get("sourcetype"), query.Get("index"))
+			if truncated {
+				markTruncated(e)
+			}
+			events = append(events, e)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			respondError(w, err)
+			return
+		}
+	}
+	h.accept(w, events)
+}
+
+func (h *hecInput) handleHealth(w http.ResponseWriter, r *http.Request) {
+	if len(h.queue) == cap(h.queue) {
+		w.Header().Set("Retry-After", strconv.Itoa(hecRetryAfter))
+		respond(w, http.StatusServiceUnavailable, hecServerBusy)
+		return
+	}
+	respond(w, http.StatusOK, hecHealthy)
+}
+
+// accept queues all the events of a request, or none of them if they do not fit. Requests with more events than the
+// queue holds are rejected as too large rather than busy, as retrying them would never succeed.
+func (h *hecInput) accept(w http.ResponseWriter, events []*logEvent) {
+	if len(events) == 0 {
+		respond(w, http.StatusBadRequest, hecNoData)
+		return
+	}
+	if len(events) > cap(h.queue) {
+		h.rejected.Inc(int64(len(events)))
+		respond(w, http.StatusRequestEntityTooLarge, hecTooLarge)
+		return
+	}
+	h.enqueue.Lock()
+	if len(events) > cap(h.queue)-len(h.queue) {
+		h.enqueue.Unlock()
+		h.rejected.Inc(int64(len(events)))
+		w.Header().Set("Retry-After", strconv.Itoa(hecRetryAfter))
+		respond(w, http.StatusServiceUnavailable, hecServerBusy)
+		return
+	}
+	for _, e := range events {
+		h.queue <- e
+	}
+	h.enqueue.Unlock()
+	h.received.Inc(int64(len(events)))
+	respond(w, http.StatusOK, hecSuccess)
+}
+
+// newHECRequestEvent keeps the metadata of an event posted by a HEC client. Events without time get one extracted like
+// any other event.
+func newHECRequestEvent(item hecRequestEvent) (*logEvent, error) {
+	e := &logEvent{precision: time.Millisecond}
+	switch event := item.Event.(type) {
+	case string:
+		e.raw = event
+	case map[string]interface{}:
+		raw, err := json.Marshal(event)
+		if err != nil {
+			return nil, err
+		}
+		e.raw, e.fields, e.parsed, e.modified = string(raw), event, true, true
+	default:
+		e.raw = fieldString(event)
+	}
+	if item.Time != nil {
+		t, precision, err := parseHECTime(fieldString(item.Time))
+		if err != nil {
+			return nil, err
+		}
+		e.time, e.precision = t, precision
+	} else {
+		e.time = extractTimestamp(e)
+	}
+	overrideMetadata(e, item.Host, item.Source, item.Sourcetype, item.Index)
+	for k, v := range item.Fields {
+		if e.indexed == nil {
+			e.indexed = map[string]interface{}{}
+		}
+		e.indexed[k] = indexedValue(v)
+	}
+	return e, nil
+}
+
+// parseHECTime parses epoch seconds with an optional fraction, e.g. 1433188255.500
+func parseHECTime(s string) (time.Time, time.Duration, error) {
+	seconds, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+	precision := time.Millisecond
+	if dot := strings.IndexByte(s, '.'); dot >= 0 && len(s)-dot-1 > 3 {
+		precision = time.Microsecond
+	}
+	whole, fraction := math.Modf(seconds)
+	return time.Unix(int64(whole), int64(fraction*float64(time.Second))).Round(precision), precision, nil
+}
+
+func overrideMetadata(e *logEvent, host, source, sourcetype, index string) {
+	if host != "" {
+		e.host = host
+	}
+	if source != "" {
+		e.source = source
+	}
+	if sourcetype != "" {
+		e.sourcetype = sourcetype
+	}
+	if index != "" {
+		e.index = index
+	}
+}
+
+// requestBody reads the body of a request, decompressed if gzip encoded. Both the compressed and decompressed bodies are
+// bounded by hecMaxBody, so that small gzip bodies can not expand without limit.
+func requestBody(r *http.Request) (io.Reader, error) {
+	var body io.Reader = &limitedBody{r.Body, hecMaxBody}
+	if r.Header.Get("Content-Encoding") != "gzip" {
+		return body, nil
+	}
+	gz, err := gzip.NewReader(body)
+	if err != nil {
+		return nil, err
+	}
+	return &limitedBody{gz, hecMaxBody}, nil
+}
+
+// limitedBody fails with errBodyTooLarge once more than its remaining bytes are read, unlike io.LimitReader which ends
+// as if the body was complete
+type limitedBody struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if int64(len(p)) > b.remaining+1 {
+		p = p[:b.remaining+1]
+	}
+	n, err := b.r.Read(p)
+	if int64(n) > b.remaining {
+		n = int(b.remaining)
+		b.remaining = 0
+		return n, errBodyTooLarge
+	}
+	b.remaining -= int64(n)
+	return n, err
+}
+
+// respondError answers a request whose body could not be read
+func respondError(w http.ResponseWriter, err error) {
+	if err == errBodyTooLarge {
+		respond(w, http.StatusRequestEntityTooLarge, hecTooLarge)
+		return
+	}
+	respond(w, http.StatusBadRequest, hecInvalidData)
+}
+
+func respond(w http.ResponseWriter, status int, response hecResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testHECInput(t *testing.T, queueLength int) *hecInput {
+	h, err := newHECInput("127.0.0.1:0", []string{"app-token", "agent-token"}, queueLength)
+	assert.NoError(t, err)
+	h.listener.Close()
+	return h
+}
+
+func postHEC(h *hecInput, path, token, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", path, bytes.NewBufferString(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Splunk "+token)
+	}
+	w := httptest.NewRecorder()
+	h.handler().ServeHTTP(w, req)
+	return w
+}
+
+func Test_HECInput_Events(t *testing.T) {
+	h := testHECInput(t, 10)
+	w := postHEC(h, "/services/collector/event", "app-token",
+		`{"event": "plain text", "time": 1503067035.123, "host": "app-1", "sourcetype": "app", "index": "apps"}
+		 {"event": {"level": "error", "msg": "boom"}, "time": "1503067035.123456", "fields": {"pod": "api-1", "attempt": 2}}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"text": "Success", "code": 0}`, w.Body.String())
+
+	assert.Len(t, h.queue, 2)
+	first, second := <-h.queue, <-h.queue
+	assert.Equal(t, hecEvent{Event: "plain text", Time: 1503067035.123, Host: "app-1", Sourcetype: "app", Index: "apps"}, first.hec())
+	assert.Equal(t, time.Unix(1503067035, 123456000), second.time)
+	assert.Equal(t, time.Microsecond, second.precision)
+	level, _ := second.Field("level")
+	assert.Equal(t, "error", level)
+	assert.Equal(t, map[string]interface{}{"level": "error", "msg": "boom"}, second.hec().Event, "object events are sent as objects")
+	assert.Equal(t, map[string]interface{}{"pod": "api-1", "attempt": "2"}, second.indexed)
+}
+
+func Test_HECInput_Raw(t *testing.T) {
+	h := testHECInput(t, 10)
+	buf := &bytes.Buffer{}
+	gz := gzip.NewWriter(buf)
+	gz.Write([]byte("2017-08-18T14:37:15Z first line\n\nsecond line"))
+	gz.Close()
+
+	req := httptest.NewRequest("POST", "/services/collector/raw?host=legacy-1&sourcetype=legacy", buf)
+	req.Header.Set("Authorization", "Splunk agent-token")
+	req.Header.Set("Content-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	h.handler().ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	assert.Len(t, h.queue, 2)
+	first, second := <-h.queue, <-h.queue
+	assert.Equal(t, "2017-08-18T14:37:15Z first line\n", first.raw)
+	assert.Equal(t, time.Date(2017, time.August, 18, 14, 37, 15, 0, time.UTC), first.time.UTC())
+	assert.Equal(t, "legacy-1", first.host)
+	assert.Equal(t, "legacy", second.sourcetype)
+	assert.Equal(t, "second line", second.raw)
+}
+
+func Test_HECInput_RejectsInvalidRequests(t *testing.T) {
+	h := testHECInput(t, 10)
+	for _, test := range []struct {
+		token, body string
+		status      int
+		response    string
+	}{
+		{"", `{"event": "e"}`, http.StatusUnauthorized, `{"text": "Token is required", "code": 2}`},
+		{"other-token", `{"event": "e"}`, http.StatusForbidden, `{"text": "Invalid token", "code": 4}`},
+		{"app-token", ``, http.StatusBadRequest, `{"text": "No data", "code": 5}`},
+		{"app-token", `{"event": "e"`, http.StatusBadRequest, `{"text": "Invalid data format", "code": 6}`},
+		{"app-token", `{"event": "e", "time": "yesterday"}`, http.StatusBadRequest, `{"text": "Invalid data format", "code": 6}`},
+		{"app-token", `{"event": "e"} {"host": "app-1"}`, http.StatusBadRequest, `{"text": "Event field is required", "code": 12}`},
+	} {
+		w := postHEC(h, "/services/collector/event", test.token, test.body)
+		assert.Equal(t, test.status, w.Code, test.body)
+		assert.JSONEq(t, test.response, w.Body.String(), test.body)
+	}
+	assert.Empty(t, h.queue)
+}
+
+func Test_HECInput_BackpressureRejectsWholeRequests(t *testing.T) {
+	h := testHECInput(t, 3)
+	assert.Equal(t, http.StatusOK, postHEC(h, "/services/collector/event", "app-token", `{"event": "1"} {"event": "2"}`).Code)
+
+	w := postHEC(h, "/services/collector/event", "app-token", `{"event": "3"} {"event": "4"}`)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "5", w.Header().Get("Retry-After"))
+	assert.JSONEq(t, `{"text": "Server is busy", "code": 9}`, w.Body.String())
+	assert.Len(t, h.queue, 2)
+
+	assert.Equal(t, http.StatusOK, postHEC(h, "/services/collector/event", "app-token", `{"event": "3"}`).Code)
+	health := httptest.NewRecorder()
+	h.handler().ServeHTTP(health, httptest.NewRequest("GET", "/services/collector/health", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, health.Code)
+}
+
+func Test_HECInput_RejectsTooLargeRequests(t *testing.T) {
+	h := testHECInput(t, 3)
+	w := postHEC(h, "/services/collector/event", "app-token", `{"event": "1"} {"event": "2"} {"event": "3"} {"event": "4"}`)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code, "requests larger than the queue would never be accepted")
+	assert.Empty(t, w.Header().Get("Retry-After"))
+	assert.JSONEq(t, `{"text": "Request entity too large", "code": 6}`, w.Body.String())
+
+	buf := &bytes.Buffer{}
+	gz := gzip.NewWriter(buf)
+	gz.Write([]byte(strings.Repeat("a", hecMaxBody) + "\n"))
+	gz.Close()
+	assert.True(t, buf.Len() < hecMaxBody)
+	req := httptest.NewRequest("POST", "/services/collector/raw", buf)
+	req.Header.Set("Authorization", "Splunk agent-token")
+	req.Header.Set("Content-Encoding", "gzip")
+	w = httptest.NewRecorder()
+	h.handler().ServeHTTP(w, req)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code, "gzip bodies are bounded once decompressed")
+	assert.Empty(t, h.queue)
+
+	body := &limitedBody{strings.NewReader("1234"), 4}
+	b := make([]byte, 8)
+	n, err := body.Read(b)
+	assert.Equal(t, 4, n)
+	assert.NoError(t, err)
+	_, err = body.Read(b)
+	assert.Equal(t, io.EOF, err, "bodies of exactly the limit are complete")
+}
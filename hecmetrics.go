@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const metricNamePrefix = "metric_name:"
+
+var (
+	metricFormatsFlag    string
+	metricField          string
+	metricsIndex         string
+	metricDimensionsFlag string
+	metricFormats        []string
+	metricDimensions     []string
+)
+
+func setupMetricEvents() error {
+	metricFormats, metricDimensions = splitList(metricFormatsFlag), splitList(metricDimensionsFlag)
+	for _, format := range metricFormats {
+		if format != "statsd" && format != "json" {
+			return fmt.Errorf("unknown metric format %q, expected statsd or json", format)
+		}
+	}
+	if len(metricFormats) > 0 && hecMode == hecModeRaw {
+		return fmt.Errorf("metric events can not be sent in %v mode", hecModeRaw)
+	}
+	return nil
+}
+
+// detectMetric turns the event into a HEC metrics event if its text is a statsd line or a json object with metric_name: keys.
+// The text of a modified event is read from its fields, so that values redacted from them are not sent.
+func detectMetric(e *logEvent) {
+	if len(metricFormats) == 0 {
+		return
+	}
+	text := e.rawBody()
+	if metricField != "" {
+		v, found := e.Field(metricField)
+		if !found {
+			return
+		}
+		text = v
+	}
+	text = strings.TrimSpace(text)
+
+	var fields map[string]interface{}
+	for _, format := range metricFormats {
+		switch format {
+		case "statsd":
+			fields = parseStatsd(text)
+		case "json":
+			fields = parseJSONMetric(text)
+		}
+		if fields != nil {
+			break
+		}
+	}
+	if fields == nil {
+		return
+	}
+
+	for _, dimension := range metricDimensions {
+		if v, found := e.Field(dimension); found {
+			if _, exists := fields[dimension]; !exists {
+				fields[dimension] = v
+			}
+		}
+	}
+	e.metric = fields
+	if metricsIndex != "" {
+		e.index = metricsIndex
+	}
+}
+
+// parseStatsd reads a single statsd line, name:value|type[|@rate][|#tag:value,...], as HEC metric fields.
+// Tags become dimensions and sampled counters are scaled up by their rate.
+func parseStatsd(s string) map[string]interface{} {
+	parts := strings.Split(s, "|")
+	if len(parts) < 2 {
+		return nil
+	}
+	nameValue := strings.SplitN(parts[0], ":", 2)
+	if len(nameValue) != 2 || nameValue[0] == "" || strings.ContainsAny(nameValue[0], " \t") {
+		return nil
+	}
+	value, err := strconv.ParseFloat(nameValue[1], 64)
+	if err != nil {
+		return nil
+	}
+	metricType := parts[1]
+	switch metricType {
+	case "c", "g", "ms", "h", "s", "d":
+	default:
+		return nil
+	}
+
+	fields := map[string]interface{}{"metric_type": metricType}
+	for _, part := range parts[2:] {
+		switch {
+		case strings.HasPrefix(part, "@"):
+			rate, err := strconv.ParseFloat(part[1:], 64)
+			if err != nil || rate <= 0 || rate > 1 {
+				return nil
+			}
+			if metricType == "c" {
+				value = value / rate
+			}
+		case strings.HasPrefix(part, "#"):
+			for _, tag := range strings.Split(part[1:], ",") {
+				kv := strings.SplitN(tag, ":", 2)
+				if len(kv) == 2 {
+					fields[kv[0]] = kv[1]
+				} else if kv[0] != "" {
+					fields[kv[0]] = ""
+				}
+			}
+		default:
+			return nil
+		}
+	}
+	fields[metricNamePrefix+nameValue[0]] = value
+	return fields
+}
+
+// parseJSONMetric reads a json object in the HEC multiple metric format: numeric metric_name:* keys and scalar dimensions
+func parseJSONMetric(s string) map[string]interface{} {
+	payload := parseEmbeddedJSON(s)
+	if payload == nil {
+		return nil
+	}
+	fields := map[string]interface{}{}
+	metricCount := 0
+	for k, v := range payload {
+		if strings.HasPrefix(k, metricNamePrefix) {
+			n, ok := v.(json.Number)
+			if !ok {
+				return nil
+			}
+			value, err := n.Float64()
+			if err != nil {
+				return nil
+			}
+			fields[k] = value
+			metricCount++
+			continue
+		}
+		switch v.(type) {
+		case string, json.Number, bool:
+			fields[k] = fieldString(v)
+		}
+	}
+	if metricCount == 0 {
+		return nil
+	}
+	return fields
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func withMetricEvents(t *testing.T, formats, field, index, dimensions string) func() {
+	metricFormatsFlag, metricField, metricsIndex, metricDimensionsFlag = formats, field, index, dimensions
+	assert.NoError(t, setupMetricEvents())
+	return func() {
+		metricFormatsFlag, metricField, metricsIndex, metricDimensionsFlag = "", "", "", ""
+		metricFormats, metricDimensions = nil, nil
+	}
+}
+
+func Test_MetricEvent_Statsd(t *testing.T) {
+	defer withMetricEvents(t, "statsd,json", "MESSAGE", "upp_metrics", "SYSTEMD_UNIT")()
+
+	e := newLogEvent(`{"@time":"2017-08-18T14:37:15.639Z","SYSTEMD_UNIT":"annotations-mapper@2.service","MESSAGE":"mapper.requests:3|c|@0.5|#status:200,method:POST"}`)
+	e.decorate()
+	doc, err := json.Marshal(e.hec())
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"event":"metric","time":1503067035.639,"index":"upp_metrics","fields":{"metric_name:mapper.requests":6,"metric_type":"c","status":"200","method":"POST","SYSTEMD_UNIT":"annotations-mapper@2.service"}}`, string(doc))
+}
+
+func Test_MetricEvent_JSON(t *testing.T) {
+	defer withMetricEvents(t, "json", "", "upp_metrics", "")()
+	defer withIndexedFields(t, "environment=prod", "", 1024)()
+
+	e := newLogEvent(`{"metric_name:heap.used":1024.5,"metric_name:goroutines":42,"service_name":"annotations-mapper","tags":["a"]}`)
+	e.decorate()
+	item := e.hec()
+	assert.Equal(t, "metric", item.Event)
+	assert.Equal(t, "upp_metrics", item.Index)
+	assert.Equal(t, map[string]interface{}{
+		"metric_name:heap.used":  1024.5,
+		"metric_name:goroutines": float64(42),
+		"service_name":           "annotations-mapper",
+		"environment":            "prod",
+	}, item.Fields)
+}
+
+func Test_MetricEvent_IgnoresLogLines(t *testing.T) {
+	defer withMetricEvents(t, "statsd,json", "MESSAGE", "upp_metrics", "")()
+
+	for _, line := range []string{
+		`{"MESSAGE":"Successfully mapped"}`,
+		`{"MESSAGE":"status:200|ok"}`,
+		`{"MESSAGE":"mapper.requests:abc|c"}`,
+		`{"MESSAGE":"{\"metric_name:x\":\"high\"}"}`,
+		`{"MESSAGE":"{\"level\":\"info\"}"}`,
+		`{"msg":"mapper.requests:3|c"}`,
+	} {
+		e := newLogEvent(line)
+		e.decorate()
+		assert.Nil(t, e.metric, line)
+		assert.Empty(t, e.index, line)
+	}
+}
+
+func Test_SetupMetricEvents_Invalid(t *testing.T) {
+	defer withMetricEvents(t, "", "", "", "")()
+	defer func(mode string) { hecMode = mode }(hecMode)
+
+	metricFormatsFlag = "prometheus"
+	assert.Error(t, setupMetricEvents())
+
+	metricFormatsFlag, hecMode = "statsd", hecModeRaw
+	assert.Error(t, setupMetricEvents())
+}
+
+func Test_MetricEvent_RedactedFields(t *testing.T) {
+	defer withMetricEvents(t, "json", "", "", "")()
+	defer func() { redactBuiltins = "" }()
+	redactBuiltins = "email"
+	rules, err := redactRulesFromFlags()
+	assert.NoError(t, err)
+	r, err := newRedactor(rules, "")
+	assert.NoError(t, err)
+
+	e := redactOne(t, r, `{"metric_name:logins":1,"user":"jane@example.com"}`)
+	e.decorate()
+	doc, err := json.Marshal(e.hec())
+	assert.NoError(t, err)
+	assert.NotContains(t, string(doc), "jane@example.com")
+	assert.JSONEq(t, `{"event":"metric","time":`+fmt.Sprint(e.hec().Time)+`,"fields":{"metric_name:logins":1,"user":"****"}}`, string(doc))
+}
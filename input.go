@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+var (
+	inputsFlag  string
+	inputBuffer int
+	// inputs are all the inputs read by the forwarder, whichever pipeline they feed
+	inputs []namedInput
+)
+
+// input reads events from a source and sends them on the events channel. run returns when the source is exhausted.
+type input interface {
+	run(events chan<- *logEvent) error
+}
+
+type namedInput struct {
+	name string
+	input
+}
+
+// readerInput reads the messages of a stream framed by -framing, e.g. from stdin
+type readerInput struct {
+	r *bufio.Reader
+}
+
+func (in readerInput) run(events chan<- *logEvent) error {
+	return readFrames(in.r, func(e *logEvent) { events <- e })
+}
+
+func setupInputs() error {
+	if err := checkFraming(framing, maxMessageBytes); err != nil {
+		return err
+	}
+	var err error
+	inputs, err = newInputs(splitList(inputsFlag))
+	return err
+}
+
+// newInputs creates the inputs of the given names, each of them at most once
+func newInputs(names []string) ([]namedInput, error) {
+	created := []namedInput{}
+	for _, name := range names {
+		for _, in := range created {
+			if in.name == name {
+				return nil, fmt.Errorf("input %v is listed twice", name)
+			}
+		}
+		in, err := newInput(name)
+		if err != nil {
+			return nil, err
+		}
+		created = append(created, namedInput{name, in})
+	}
+	if len(created) == 0 {
+		return nil, fmt.Errorf("no inputs configured")
+	}
+	return created, nil
+}
+
+func newInput(name string) (input, error) {
+	switch name {
+	case "stdin":
+		if br == nil {
+			br = bufio.NewReader(os.Stdin)
+		}
+		return readerInput{br}, nil
+	case "syslog":
+		s, err := newSyslogInput(syslogUDP, syslogTCP)
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	case "hec":
+		h, err := newHECInput(hecListen, splitList(hecTokensFlag), hecQueueLength)
+		if err != nil {
+			return nil, err
+		}
+		return h, nil
+	case "file":
+		if stateDir == "" {
+			return nil, fmt.Errorf("file input requires -stateDir to checkpoint offsets")
+		}
+		if err := os.MkdirAll(stateDir, 0755); err != nil {
+			return nil, err
+		}
+		f, err := newFileInput(splitList(filePatterns), filepath.Join(stateDir, fileCheckpointFile))
+		if err != nil {
+			return nil, err
+		}
+		return f, nil
+	case "journal":
+		if stateDir == "" {
+			return nil, fmt.Errorf("journal input requires -stateDir to persist its cursor")
+		}
+		if err := os.MkdirAll(stateDir, 0755); err != nil {
+			return nil, err
+		}
+		j, err := newJournalInput(journalCommand, journalFile, splitList(journalUnits), journalPriority, filepath.Join(stateDir, journalCursorFile))
+		if err != nil {
+			return nil, err
+		}
+		return j, nil
+	case "kubernetes":
+		if stateDir == "" {
+			return nil, fmt.Errorf("kubernetes input requires -stateDir to checkpoint offsets")
+		}
+		if err := os.MkdirAll(stateDir, 0755); err != nil {
+			return nil, err
+		}
+		k, err := newKubernetesInput(splitList(kubernetesLogs), kubernetesMetadata, filepath.Join(stateDir, kubernetesCheckpointFile))
+		if err != nil {
+			return nil, err
+		}
+		return k, nil
+	case "socket":
+		s, err := newSocketInput(socketPath, socketType, socketMode)
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	case "fifo":
+		f, err := newFIFOInput(fifoPath)
+		if err != nil {
+			return nil, err
+		}
+		return f, nil
+	default:
+		return nil, fmt.Errorf("unknown input %q, expected stdin, syslog, hec, file, journal, kubernetes, socket or fifo", name)
+	}
+}
+
+// checkpointer is implemented by inputs persisting how far their events were acknowledged
+type checkpointer interface {
+	save() error
+}
+
+// saveCheckpoints persists the acknowledged positions of the inputs, e.g. once all batches are delivered before exit
+func saveCheckpoints(inputs []namedInput) {
+	for _, in := range inputs {
+		if c, ok := in.input.(checkpointer); ok {
+			if err := c.save(); err != nil {
+				log.Printf("Failed to save the checkpoint of input %v: %v\n", in.name, err)
+			}
+		}
+	}
+}
+
+// runInputs starts the inputs and closes the returned channel once all of them are done. Every input has its own
+// buffer of -inputBuffer events, so that a burst on one input does not hold the others up, and its own metrics.
+func runInputs(inputs []namedInput) <-chan *logEvent {
+	events := make(chan *logEvent)
+	var running sync.WaitGroup
+	for _, in := range inputs {
+		running.Add(2)
+		buffer := make(chan *logEvent, inputBuffer)
+		received := metrics.GetOrRegisterCounter("input."+in.name+".events", metrics.DefaultRegistry)
+		queued := metrics.GetOrRegisterGauge("input."+in.name+".queue", metrics.DefaultRegistry)
+		go func(in namedInput) {
+			defer running.Done()
+			defer close(buffer)
+			if err := in.run(buffer); err != nil {
+				log.Printf("Input %v failed: %v\n", in.name, err)
+			}
+		}(in)
+		go func() {
+			defer running.Done()
+			for e := range buffer {
+				received.Inc(1)
+				queued.Update(int64(len(buffer)))
+				events <- e
+			}
+		}()
+	}
+	go func() {
+		running.Wait()
+		close(events)
+	}()
+	return events
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"strconv"
+	"time"
+)
+
+const (
+	journaldRealtimeField = "__REALTIME_TIMESTAMP"
+	journaldUnitField     = "SYSTEMD_UNIT"
+)
+
+var (
+	journald            bool
+	sourcetypeField     string
+	stripJournaldFields bool
+	journaldHostFields  = []string{"_HOSTNAME", "HOSTNAME"}
+)
+
+// parseJournald maps the fields of a `journalctl --output=json` entry onto HEC metadata
+func parseJournald(e *logEvent) {
+	if e.Fields() == nil {
+		return
+	}
+	e.precision = time.Microsecond
+
+	mapped := []string{}
+	for _, field := range journaldHostFields {
+		if host, found := e.Field(field); found && host != "" {
+			e.host = host
+			mapped = append(mapped, field)
+			break
+		}
+	}
+	if unit, found := e.Field(journaldUnitField); found {
+		e.source = unit
+		mapped = append(mapped, journaldUnitField)
+	}
+	if sourcetypeField != "" {
+		if sourcetype, found := e.Field(sourcetypeField); found {
+			e.sourcetype = sourcetype
+			mapped = append(mapped, sourcetypeField)
+		}
+	}
+	if realtime, found := e.Field(journaldRealtimeField); found {
+		micros, err := strconv.ParseInt(realtime, 10, 64)
+		if err == nil {
+			e.time = time.Unix(0, micros*int64(time.Microsecond))
+			mapped = append(mapped, journaldRealtimeField)
+		}
+	}
+
+	if stripJournaldFields {
+		for _, field := range mapped {
+			e.DeleteField(field)
+		}
+	}
+}
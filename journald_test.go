@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const journaldEvent = `{"__REALTIME_TIMESTAMP":"1503067035639583","_HOSTNAME":"ip-10-172-40-48","HOSTNAME":"test_host","SYSTEMD_UNIT":"annotations-mapper@2.service","SYSLOG_IDENTIFIER":"annotations-mapper","MESSAGE":"Successfully mapped"}`
+
+func withJournald(sourcetype string, strip bool) func() {
+	journald, sourcetypeField, stripJournaldFields = true, sourcetype, strip
+	return func() {
+		journald, sourcetypeField, stripJournaldFields = false, "", false
+	}
+}
+
+func Test_Journald_MapsMetadata(t *testing.T) {
+	defer withJournald("SYSLOG_IDENTIFIER", false)()
+
+	item := newLogEvent(journaldEvent).hec()
+	assert.Equal(t, "ip-10-172-40-48", item.Host)
+	assert.Equal(t, "annotations-mapper@2.service", item.Source)
+	assert.Equal(t, "annotations-mapper", item.Sourcetype)
+	assert.Equal(t, 1503067035.639583, item.Time)
+	assert.Equal(t, journaldEvent, item.Event)
+}
+
+func Test_Journald_StripsMappedFields(t *testing.T) {
+	defer withJournald("SYSLOG_IDENTIFIER", true)()
+
+	doc, err := json.Marshal(newLogEvent(journaldEvent).hec())
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"event":{"HOSTNAME":"test_host","MESSAGE":"Successfully mapped"},"time":1503067035.639583,"host":"ip-10-172-40-48","source":"annotations-mapper@2.service","sourcetype":"annotations-mapper"}`, string(doc))
+}
+
+func Test_Journald_FallsBackToHostname(t *testing.T) {
+	defer withJournald("", false)()
+
+	item := newLogEvent(`{"HOSTNAME":"test_host","MESSAGE":"2017-08-18T14:37:15.639Z started"}`).hec()
+	assert.Equal(t, "test_host", item.Host)
+	assert.Empty(t, item.Source)
+	assert.Equal(t, 1503067035.639, item.Time)
+}
+
+func Test_Journald_NonJSONLine(t *testing.T) {
+	defer withJournald("", true)()
+
+	item := newLogEvent("not json").hec()
+	assert.Equal(t, "not json", item.Event)
+	assert.Empty(t, item.Host)
+}
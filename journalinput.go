@@ -0,0 +1,338 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+const (
+	journalCursorFile   = "journal.cursor"
+	journalCursorField  = "__CURSOR"
+	journalSaveInterval = time.Second
+)
+
+var (
+	journalCommand  string
+	journalFile     string
+	journalUnits    string
+	journalPriority string
+)
+
+// journalInput reads journal entries in the export format, either following `journalctl` or from a recorded export file.
+// It persists the cursor of the last acknowledged entry and resumes after it: journalctl is run with --after-cursor and
+// the entries of a recorded file up to the cursor are skipped.
+type journalInput struct {
+	command  string
+	file     string
+	units    []string
+	priority int
+	path     string
+	mu       sync.Mutex // guards the cursors
+	cursor   string
+	saved    string
+	pending  []*pendingCursor
+	entries  metrics.Counter
+}
+
+type pendingCursor struct {
+	cursor string
+	acked  bool
+}
+
+func newJournalInput(command, file string, units []string, priority, cursorPath string) (*journalInput, error) {
+	in := &journalInput{
+		command:  command,
+		file:     file,
+		units:    units,
+		priority: len(syslogSeverities) - 1,
+		path:     cursorPath,
+		entries:  metrics.GetOrRegisterCounter("journal_input.entries", metrics.DefaultRegistry),
+	}
+	for _, unit := range units {
+		if _, err := path.Match(unit, ""); err != nil {
+			return nil, fmt.Errorf("journal unit %q: %v", unit, err)
+		}
+	}
+	if priority != "" {
+		p, err := parsePriority(priority)
+		if err != nil {
+			return nil, err
+		}
+		in.priority = p
+	}
+	data, err := ioutil.ReadFile(cursorPath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	in.cursor = strings.TrimSpace(string(data))
+	in.saved = in.cursor
+	return in, nil
+}
+
+// parsePriority reads a syslog severity by name or number, e.g. warning or 4
+func parsePriority(s string) (int, error) {
+	for i, name := range syslogSeverities {
+		if s == name {
+			return i, nil
+		}
+	}
+	p, err := strconv.Atoi(s)
+	if err != nil || p < 0 || p >= len(syslogSeverities) {
+		return 0, fmt.Errorf("invalid journal priority %q, expected 0-7 or one of %v", s, strings.Join(syslogSeverities, ", "))
+	}
+	return p, nil
+}
+
+// args are the journalctl arguments following the journal after the acknowledged cursor
+func (in *journalInput) args(cursor string) []string {
+	args := []string{"--follow", "--output=export"}
+	if cursor != "" {
+		args = append(args, "--after-cursor="+cursor)
+	} else {
+		args = append(args, "--lines=0")
+	}
+	for _, unit := range in.units {
+		args = append(args, "--unit="+unit)
+	}
+	if in.priority < len(syslogSeverities)-1 {
+		args = append(args, "--priority="+strconv.Itoa(in.priority))
+	}
+	return args
+}
+
+func (in *journalInput) run(events chan<- *logEvent) error {
+	in.mu.Lock()
+	cursor := in.cursor
+	in.mu.Unlock()
+
+	var r io.Reader
+	var cmd *exec.Cmd
+	skipTo := ""
+	if in.file != "" {
+		f, err := os.Open(in.file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r, skipTo = f, cursor
+	} else {
+		cmd = exec.Command(in.command, in.args(cursor)...)
+		cmd.Stderr = os.Stderr
+		out, err := cmd.StdoutPipe()
+		if err != nil {
+			return err
+		}
+		if err := cmd.Start(); err != nil {
+			return err
+		}
+		r = out
+	}
+
+	stop := make(chan bool)
+	defer close(stop)
+	go func() {
+		ticker := time.NewTicker(journalSaveInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				if err := in.save(); err != nil {
+					log.Printf("Failed to save journal cursor: %v\n", err)
+				}
+			}
+		}
+	}()
+
+	br := bufio.NewReader(r)
+	for {
+		fields, truncated, err := readJournalEntry(br)
+		if fields != nil {
+			cursor, _ := fields[journalCursorField].(string)
+			if skipTo != "" {
+				if cursor == skipTo {
+					skipTo = ""
+				}
+				continue
+			}
+			if e := in.event(fields, cursor); e != nil {
+				if truncated {
+					markTruncated(e)
+				}
+				events <- e
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if cmd != nil {
+				cmd.Process.Kill()
+				cmd.Wait()
+			}
+			return err
+		}
+	}
+	if cmd != nil {
+		return cmd.Wait()
+	}
+	return nil
+}
+
+// event turns a journal entry into an event acknowledging its cursor, or returns nil if the entry does not match the
+// unit and priority filters
+func (in *journalInput) event(fields map[string]interface{}, cursor string) *logEvent {
+	in.entries.Inc(1)
+	p := &pendingCursor{cursor: cursor}
+	in.mu.Lock()
+	in.pending = append(in.pending, p)
+	in.mu.Unlock()
+
+	if !in.matches(fields) {
+		in.ack(p)
+		return nil
+	}
+	e := newJournalEvent(fields)
+	e.acks = append(e.acks, func() { in.ack(p) })
+	return e
+}
+
+func (in *journalInput) matches(fields map[string]interface{}) bool {
+	if priority, found := fields["PRIORITY"]; found {
+		if p, err := strconv.Atoi(fieldString(priority)); err == nil && p > in.priority {
+			return false
+		}
+	}
+	if len(in.units) == 0 {
+		return true
+	}
+	unit, found := fields["_SYSTEMD_UNIT"]
+	if !found {
+		unit = fields[journaldUnitField]
+	}
+	for _, pattern := range in.units {
+		if matched, _ := path.Match(pattern, fieldString(unit)); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// ack advances the cursor over the entries acknowledged in the order they were read
+func (in *journalInput) ack(p *pendingCursor) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	p.acked = true
+	for len(in.pending) > 0 && in.pending[0].acked {
+		if in.pending[0].cursor != "" {
+			in.cursor = in.pending[0].cursor
+		}
+		in.pending = in.pending[1:]
+	}
+}
+
+func (in *journalInput) save() error {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	if in.cursor == in.saved {
+		return nil
+	}
+	if err := writeFileAtomic(in.path, []byte(in.cursor+"\n")); err != nil {
+		return err
+	}
+	in.saved = in.cursor
+	return nil
+}
+
+// newJournalEvent creates an event from the fields of a journal entry, like a line of `journalctl --output=json` in
+// journald mode
+func newJournalEvent(fields map[string]interface{}) *logEvent {
+	raw, _ := json.Marshal(fields)
+	e := &logEvent{raw: string(raw), fields: fields, parsed: true, precision: time.Millisecond}
+	parseJournald(e)
+	if e.source == "" {
+		if unit, found := fields["_SYSTEMD_UNIT"]; found {
+			e.source = fieldString(unit)
+		}
+	}
+	if embeddedField != "" {
+		decodeEmbedded(e, embeddedField, strings.Split(embeddedFormats, ","), embeddedCollision)
+	}
+	if e.time.IsZero() {
+		e.time = extractTimestamp(e)
+	}
+	return e
+}
+
+// readJournalEntry reads an entry of the journal export format: KEY=value lines, or a KEY line followed by the 64 bit
+// little endian size and the bytes of a binary value, with entries separated by an empty line. See
+// https://systemd.io/JOURNAL_EXPORT_FORMATS/
+// Fields longer than -maxMessageBytes are truncated, which it tells, so that a large entry does not stop the input.
+func readJournalEntry(r *bufio.Reader) (map[string]interface{}, bool, error) {
+	var fields map[string]interface{}
+	lines := &delimitedFramer{r, '\n', maxMessageBytes, false}
+	truncated := false
+	for {
+		line, cut, err := lines.next()
+		if err != nil {
+			return fields, truncated, err
+		}
+		truncated = truncated || cut
+		if line == "" {
+			if fields != nil {
+				return fields, truncated, nil
+			}
+			continue
+		}
+		if fields == nil {
+			fields = map[string]interface{}{}
+		}
+		if eq := strings.IndexByte(line, '='); eq >= 0 {
+			fields[line[:eq]] = line[eq+1:]
+			continue
+		}
+		var size uint64
+		if err := binary.Read(r, binary.LittleEndian, &size); err != nil {
+			return nil, false, err
+		}
+		keep := size
+		if keep > uint64(maxMessageBytes) {
+			keep, truncated = uint64(maxMessageBytes), true
+		}
+		value := make([]byte, keep)
+		if _, err := io.ReadFull(r, value); err != nil {
+			return nil, false, unexpectedEOF(err)
+		}
+		// the rest of the value and the newline ending it
+		if err := discard(r, size-keep+1); err != nil {
+			return nil, false, unexpectedEOF(err)
+		}
+		fields[line] = string(value)
+	}
+}
+
+func discard(r *bufio.Reader, n uint64) error {
+	for ; n > math.MaxInt32; n -= math.MaxInt32 {
+		if _, err := r.Discard(math.MaxInt32); err != nil {
+			return err
+		}
+	}
+	_, err := r.Discard(int(n))
+	return err
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func readJournal(t *testing.T, in *journalInput) []*logEvent {
+	events := make(chan *logEvent, 100)
+	assert.NoError(t, in.run(events))
+	close(events)
+	read := []*logEvent{}
+	for e := range events {
+		read = append(read, e)
+	}
+	return read
+}
+
+func messages(events []*logEvent) []string {
+	list := []string{}
+	for _, e := range events {
+		message, _ := e.Field("MESSAGE")
+		list = append(list, message)
+	}
+	return list
+}
+
+func Test_ReadJournalEntry(t *testing.T) {
+	f, err := os.Open("testdata/journal.export")
+	assert.NoError(t, err)
+	defer f.Close()
+	r := bufio.NewReader(f)
+
+	entries := []map[string]interface{}{}
+	for {
+		fields, _, err := readJournalEntry(r)
+		if fields != nil {
+			entries = append(entries, fields)
+		}
+		if err != nil {
+			break
+		}
+	}
+	assert.Len(t, entries, 4)
+	assert.Equal(t, "s=739ad463;i=1", entries[0][journalCursorField])
+	assert.Equal(t, "java.lang.IllegalStateException: boom\n    at com.ft.Api.get(Api.java:42)", entries[2]["MESSAGE"])
+
+	_, _, err = readJournalEntry(bufio.NewReader(strings.NewReader("MESSAGE\n\x10\x00\x00")))
+	assert.Error(t, err)
+}
+
+func Test_ReadJournalEntry_TruncatesLargeFields(t *testing.T) {
+	defer withFraming(framingNewline, 16)()
+	r := bufio.NewReader(strings.NewReader("MESSAGE\n\x14\x00\x00\x00\x00\x00\x00\x00binary value twenty!\n\n" +
+		"MESSAGE=long text line\n\nMESSAGE=short\n\n"))
+
+	fields, truncated, err := readJournalEntry(r)
+	assert.NoError(t, err)
+	assert.True(t, truncated)
+	assert.Equal(t, "binary value twe", fields["MESSAGE"])
+	fields, truncated, err = readJournalEntry(r)
+	assert.NoError(t, err)
+	assert.True(t, truncated)
+	assert.Equal(t, "long tex", fields["MESSAGE"], "the key counts towards the length of a text line")
+	fields, truncated, err = readJournalEntry(r)
+	assert.NoError(t, err, "large fields do not stop the input")
+	assert.False(t, truncated)
+	assert.Equal(t, "short", fields["MESSAGE"])
+}
+
+func Test_JournalInput_ResumesAfterAcknowledgedCursor(t *testing.T) {
+	dir, err := ioutil.TempDir("", "journal")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	cursorPath := filepath.Join(dir, journalCursorFile)
+
+	in, err := newJournalInput("journalctl", "testdata/journal.export", nil, "", cursorPath)
+	assert.NoError(t, err)
+	events := readJournal(t, in)
+	assert.Equal(t, []string{"Request served", "container started", "java.lang.IllegalStateException: boom\n    at com.ft.Api.get(Api.java:42)", "debug details"}, messages(events))
+	assert.Equal(t, "content-api@1.service", events[0].source)
+	assert.Equal(t, "ip-10-0-0-1", events[0].host)
+	assert.Equal(t, time.Unix(1503067035, 0), events[0].time)
+
+	events[1].done()
+	events[0].done()
+	assert.NoError(t, in.save())
+	saved, err := ioutil.ReadFile(cursorPath)
+	assert.NoError(t, err)
+	assert.Equal(t, "s=739ad463;i=2\n", string(saved))
+
+	restarted, err := newJournalInput("journalctl", "testdata/journal.export", nil, "", cursorPath)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"--follow", "--output=export", "--after-cursor=s=739ad463;i=2"}, restarted.args(restarted.cursor))
+	assert.Len(t, readJournal(t, restarted), 2)
+}
+
+func Test_JournalInput_FiltersUnitsAndPriority(t *testing.T) {
+	dir, err := ioutil.TempDir("", "journal")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	in, err := newJournalInput("journalctl", "testdata/journal.export", []string{"content-api@*.service"}, "info", filepath.Join(dir, journalCursorFile))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"--follow", "--output=export", "--lines=0", "--unit=content-api@*.service", "--priority=6"}, in.args(""))
+
+	events := readJournal(t, in)
+	assert.Equal(t, []string{"Request served", "java.lang.IllegalStateException: boom\n    at com.ft.Api.get(Api.java:42)"}, messages(events))
+
+	// entries filtered out are acknowledged as soon as the entries before them are
+	events[0].done()
+	assert.Equal(t, "s=739ad463;i=2", in.cursor)
+	events[1].done()
+	assert.Equal(t, "s=739ad463;i=4", in.cursor)
+}
+
+func Test_NewJournalInput_Invalid(t *testing.T) {
+	_, err := newJournalInput("journalctl", "", nil, "loud", filepath.Join(os.TempDir(), journalCursorFile))
+	assert.Error(t, err)
+	_, err = newJournalInput("journalctl", "", []string{"["}, "", filepath.Join(os.TempDir(), journalCursorFile))
+	assert.Error(t, err)
+}
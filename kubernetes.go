@@ -0,0 +1,247 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+const (
+	kubernetesCheckpointFile = "kubernetes.json"
+	// the metadata cache file is checked for changes at most this often
+	kubernetesMetadataCheck = time.Second
+)
+
+var (
+	kubernetesLogs     string
+	kubernetesMetadata string
+
+	// containerLogName matches the file names of /var/log/containers: <pod>_<namespace>_<container>-<container id>.log
+	containerLogName = regexp.MustCompile(`^([^_]+)_([^_]+)_(.+)-([0-9a-f]{64})\.log$`)
+)
+
+// containerLine is a line of a container log, written by docker's json-file logging driver or by a CRI runtime
+type containerLine struct {
+	time    time.Time
+	stream  string
+	text    string
+	partial bool
+}
+
+// containerLogs decodes the container logs of a Kubernetes node. Lines split by the runtime are reassembled per stream,
+// and events get the pod, namespace and container taken from the file name as indexed fields, with the pod metadata of
+// an optional cache file.
+type containerLogs struct {
+	metadataPath string
+	metadata     map[string]map[string]interface{}
+	modified     time.Time
+	checked      time.Time
+	partials     map[*tailedFile]map[string]*partialLine
+}
+
+// partialLine holds the parts of a line split by the runtime, up to -maxMessageBytes
+type partialLine struct {
+	time      time.Time
+	text      string
+	truncated bool
+	acks      []func()
+}
+
+// add appends the text of a partial line, up to -maxMessageBytes and without splitting a UTF-8 character. Once the line is
+// truncated the rest of its parts are dropped.
+func (p *partialLine) add(text string) {
+	if p.truncated {
+		return
+	}
+	if room := maxMessageBytes - len(p.text); len(text) > room {
+		p.text += truncateString(text, room)
+		p.truncated = true
+	} else {
+		p.text += text
+	}
+}
+
+func newKubernetesInput(patterns []string, metadataPath, checkpointPath string) (*fileInput, error) {
+	in, err := newFileInput(patterns, checkpointPath)
+	if err != nil {
+		return nil, err
+	}
+	c := &containerLogs{metadataPath: metadataPath, partials: map[*tailedFile]map[string]*partialLine{}}
+	if metadataPath != "" {
+		if err := c.loadMetadata(); err != nil {
+			return nil, err
+		}
+	}
+	in.decoder = c
+	in.lines = metrics.GetOrRegisterCounter("kubernetes_input.lines", metrics.DefaultRegistry)
+	return in, nil
+}
+
+func (c *containerLogs) decode(t *tailedFile, line string, ack func()) *logEvent {
+	c.refreshMetadata()
+	l, err := parseContainerLine(line)
+	if err != nil { // not written by a container runtime, forwarded as it is
+		e := newLogEvent(line)
+		c.addMetadata(e, t.path, "")
+		e.acks = append(e.acks, ack)
+		return e
+	}
+
+	partials := c.partials[t]
+	if partials == nil {
+		partials = map[string]*partialLine{}
+		c.partials[t] = partials
+	}
+	held := partials[l.stream]
+	if l.partial {
+		if held == nil {
+			held = &partialLine{time: l.time}
+			partials[l.stream] = held
+		}
+		held.add(l.text)
+		held.acks = append(held.acks, ack)
+		return nil
+	}
+	if held == nil {
+		e := c.event(t.path, l.stream, l.text, l.time)
+		e.acks = []func(){ack}
+		return e
+	}
+	delete(partials, l.stream)
+	held.add(l.text)
+	held.acks = append(held.acks, ack)
+	return c.heldEvent(t.path, l.stream, held)
+}
+
+// heldEvent creates the event of a reassembled line, marked when it was truncated
+func (c *containerLogs) heldEvent(path, stream string, held *partialLine) *logEvent {
+	e := c.event(path, stream, held.text, held.time)
+	e.acks = held.acks
+	if held.truncated {
+		markTruncated(e)
+	}
+	return e
+}
+
+// close releases the partial lines of a file which is no longer read as they are
+func (c *containerLogs) close(t *tailedFile) []*logEvent {
+	events := []*logEvent{}
+	for stream, held := range c.partials[t] {
+		events = append(events, c.heldEvent(t.path, stream, held))
+	}
+	delete(c.partials, t)
+	return events
+}
+
+func (c *containerLogs) event(path, stream, text string, t time.Time) *logEvent {
+	e := &logEvent{raw: text, time: t, precision: time.Microsecond}
+	if embeddedField != "" {
+		decodeEmbedded(e, embeddedField, strings.Split(embeddedFormats, ","), embeddedCollision)
+	}
+	c.addMetadata(e, path, stream)
+	return e
+}
+
+// addMetadata sets the source and sourcetype of an event and adds the pod, namespace and container as indexed fields
+func (c *containerLogs) addMetadata(e *logEvent, path, stream string) {
+	e.source = path
+	if e.indexed == nil {
+		e.indexed = map[string]interface{}{}
+	}
+	if stream != "" {
+		e.indexed["stream"] = stream
+	}
+	match := containerLogName.FindStringSubmatch(filepath.Base(path))
+	if match == nil {
+		return
+	}
+	pod, namespace, container := match[1], match[2], match[3]
+	e.sourcetype = "kube:container:" + container
+	for k, v := range c.metadata[namespace+"/"+pod] {
+		e.indexed[k] = indexedValue(v)
+	}
+	e.indexed["pod"] = pod
+	e.indexed["namespace"] = namespace
+	e.indexed["container"] = container
+	e.indexed["container_id"] = match[4]
+}
+
+// parseContainerLine reads a docker json-file line, {"log":"text\n","stream":"stdout","time":"..."}, whose text lacks the
+// newline when it is partial, or a CRI line, "<time> <stream> <P|F> <text>", where P marks partial lines
+func parseContainerLine(line string) (containerLine, error) {
+	line = strings.TrimSuffix(line, "\n")
+	if strings.HasPrefix(line, "{") {
+		docker := struct {
+			Log    *string `json:"log"`
+			Stream string  `json:"stream"`
+			Time   string  `json:"time"`
+		}{}
+		if err := json.Unmarshal([]byte(line), &docker); err != nil {
+			return containerLine{}, err
+		}
+		if docker.Log == nil {
+			return containerLine{}, fmt.Errorf("missing log")
+		}
+		t, err := time.Parse(time.RFC3339Nano, docker.Time)
+		if err != nil {
+			return containerLine{}, err
+		}
+		text := *docker.Log
+		return containerLine{t, docker.Stream, strings.TrimSuffix(text, "\n"), !strings.HasSuffix(text, "\n")}, nil
+	}
+	parts := strings.SplitN(line, " ", 4)
+	if len(parts) < 3 || (parts[2] != "P" && parts[2] != "F") {
+		return containerLine{}, fmt.Errorf("not a CRI log line")
+	}
+	t, err := time.Parse(time.RFC3339Nano, parts[0])
+	if err != nil {
+		return containerLine{}, err
+	}
+	text := ""
+	if len(parts) == 4 {
+		text = parts[3]
+	}
+	return containerLine{t, parts[1], text, parts[2] == "P"}, nil
+}
+
+// loadMetadata reads the pod metadata cache: a json object of fields by <namespace>/<pod>, e.g. maintained by a process
+// watching the API server
+func (c *containerLogs) loadMetadata() error {
+	f, err := os.Open(c.metadataPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	metadata := map[string]map[string]interface{}{}
+	d := json.NewDecoder(f)
+	d.UseNumber()
+	if err := d.Decode(&metadata); err != nil {
+		return fmt.Errorf("%v: %v", c.metadataPath, err)
+	}
+	c.metadata, c.modified = metadata, info.ModTime()
+	return nil
+}
+
+func (c *containerLogs) refreshMetadata() {
+	if c.metadataPath == "" || time.Since(c.checked) < kubernetesMetadataCheck {
+		return
+	}
+	c.checked = time.Now()
+	if info, err := os.Stat(c.metadataPath); err != nil || info.ModTime().Equal(c.modified) {
+		return
+	}
+	if err := c.loadMetadata(); err != nil {
+		log.Printf("Keeping the previous pod metadata: %v\n", err)
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testContainerID = "4f2c9e3b7a1d8e6f5c4b3a2918f7e6d5c4b3a2918f7e6d5c4b3a2918f7e6d5c4"
+
+func testKubernetesInput(t *testing.T, dir, metadata string) *fileInput {
+	in, err := newKubernetesInput([]string{filepath.Join(dir, "*.log")}, metadata, filepath.Join(dir, kubernetesCheckpointFile))
+	assert.NoError(t, err)
+	return in
+}
+
+func Test_ParseContainerLine(t *testing.T) {
+	l, err := parseContainerLine(`{"log":"hello\n","stream":"stdout","time":"2019-01-02T03:04:05.123456789Z"}` + "\n")
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", l.text)
+	assert.Equal(t, "stdout", l.stream)
+	assert.False(t, l.partial)
+	assert.Equal(t, time.Date(2019, 1, 2, 3, 4, 5, 123456789, time.UTC), l.time)
+
+	l, err = parseContainerLine(`{"log":"hel","stream":"stderr","time":"2019-01-02T03:04:05Z"}`)
+	assert.NoError(t, err)
+	assert.Equal(t, "hel", l.text)
+	assert.True(t, l.partial)
+
+	l, err = parseContainerLine("2019-01-02T03:04:05.123456789+01:00 stderr P some text\n")
+	assert.NoError(t, err)
+	assert.Equal(t, "some text", l.text)
+	assert.Equal(t, "stderr", l.stream)
+	assert.True(t, l.partial)
+
+	l, err = parseContainerLine("2019-01-02T03:04:05Z stdout F \n")
+	assert.NoError(t, err)
+	assert.Equal(t, "", l.text)
+	assert.False(t, l.partial)
+
+	_, err = parseContainerLine("plain text\n")
+	assert.Error(t, err)
+	_, err = parseContainerLine(`{"message":"not docker"}`)
+	assert.Error(t, err)
+}
+
+func Test_KubernetesInput_ReassemblesPartialLines(t *testing.T) {
+	dir, err := ioutil.TempDir("", "containers")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "web-7d9f_shop_nginx-"+testContainerID+".log")
+	appendFile(t, path, "2019-01-02T03:04:05.000001Z stdout P first \n"+
+		"2019-01-02T03:04:05.000002Z stderr F error\n"+
+		"2019-01-02T03:04:05.000003Z stdout F half\n"+
+		"2019-01-02T03:04:06Z stdout P pending\n")
+	in := testKubernetesInput(t, dir, "")
+
+	events := scanFiles(in)
+	assert.Equal(t, []string{"error", "first half"}, raws(events))
+	e := events[1]
+	assert.Equal(t, time.Date(2019, 1, 2, 3, 4, 5, 1000, time.UTC), e.time)
+	assert.Equal(t, time.Microsecond, e.precision)
+	assert.Equal(t, path, e.source)
+	assert.Equal(t, "kube:container:nginx", e.sourcetype)
+	assert.Equal(t, map[string]interface{}{"namespace": "shop", "pod": "web-7d9f", "container": "nginx",
+		"container_id": testContainerID, "stream": "stdout"}, e.indexed)
+	assert.Equal(t, "stderr", events[0].indexed["stream"])
+
+	// the checkpoint only covers the reassembled line once it is acknowledged, not the pending partial line
+	events[0].done()
+	assert.NoError(t, in.save())
+	assert.Equal(t, int64(0), savedCheckpoints(t, dir, kubernetesCheckpointFile)[0].Offset)
+	events[1].done()
+	assert.NoError(t, in.save())
+	assert.Equal(t, int64(len("2019-01-02T03:04:05.000001Z stdout P first \n"+
+		"2019-01-02T03:04:05.000002Z stderr F error\n"+"2019-01-02T03:04:05.000003Z stdout F half\n")),
+		savedCheckpoints(t, dir, kubernetesCheckpointFile)[0].Offset)
+
+	// a removed file releases its partial line
+	assert.NoError(t, os.Remove(path))
+	events = scanFiles(in)
+	assert.Equal(t, []string{"pending"}, raws(events))
+}
+
+func Test_KubernetesInput_BoundsPartialLines(t *testing.T) {
+	defer withFraming(framingNewline, 64)()
+	dir, err := ioutil.TempDir("", "containers")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "web-7d9f_shop_nginx-"+testContainerID+".log")
+	for i := 0; i < 100; i++ {
+		appendFile(t, path, "2019-01-02T03:04:05Z stdout P 0123456\n")
+	}
+	in := testKubernetesInput(t, dir, "")
+	oversized := oversizedMessages.Count()
+
+	assert.Empty(t, scanFiles(in))
+	for _, partials := range in.decoder.(*containerLogs).partials {
+		assert.Len(t, partials["stdout"].text, 64, "partial lines are held up to the max message bytes")
+	}
+	appendFile(t, path, "2019-01-02T03:04:05Z stdout F end\n2019-01-02T03:04:06Z stdout F next\n")
+	events := scanFiles(in)
+	assert.Equal(t, []string{strings.Repeat("0123456", 9) + "0", "next"}, raws(events))
+	assert.Equal(t, "true", events[0].indexed[truncatedField])
+	assert.Nil(t, events[1].indexed[truncatedField])
+	assert.Equal(t, oversized+1, oversizedMessages.Count())
+}
+
+func Test_PartialLine_TruncatesOnCharacterBoundaries(t *testing.T) {
+	defer withFraming(framingNewline, 8)()
+	p := &partialLine{}
+	p.add("naïve")
+	p.add("té")
+	p.add("!")
+	assert.Equal(t, "naïvet", p.text)
+	assert.True(t, p.truncated)
+
+	p = &partialLine{}
+	p.add("1234567")
+	p.add("é")
+	p.add("!")
+	assert.Equal(t, "1234567", p.text, "the parts following a truncated one are dropped")
+}
+
+func Test_KubernetesInput_DockerLinesAndMetadata(t *testing.T) {
+	dir, err := ioutil.TempDir("", "containers")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	metadata := filepath.Join(dir, "metadata.json")
+	assert.NoError(t, ioutil.WriteFile(metadata, []byte(`{"shop/web-7d9f": {"app": "web", "replicas": 3}}`), 0644))
+	path := filepath.Join(dir, "web-7d9f_shop_nginx-"+testContainerID+".log")
+	appendFile(t, path, `{"log":"GET / 200\n","stream":"stdout","time":"2019-01-02T03:04:05.5Z"}`+"\n"+
+		`{"log":"long ","stream":"stdout","time":"2019-01-02T03:04:06Z"}`+"\n"+
+		`{"log":"line\n","stream":"stdout","time":"2019-01-02T03:04:07Z"}`+"\n")
+	appendFile(t, filepath.Join(dir, "other.log"), "not a container log\n")
+	in := testKubernetesInput(t, dir, metadata)
+
+	events := scanFiles(in)
+	assert.Equal(t, []string{"not a container log\n", "GET / 200", "long line"}, raws(events))
+	assert.Equal(t, "web", events[1].indexed["app"])
+	assert.Equal(t, "3", events[1].indexed["replicas"])
+	assert.Equal(t, "shop", events[1].indexed["namespace"])
+	assert.Equal(t, time.Date(2019, 1, 2, 3, 4, 6, 0, time.UTC), events[2].time)
+	assert.Equal(t, filepath.Join(dir, "other.log"), events[0].source)
+
+	// the metadata is reloaded when the file changes
+	assert.NoError(t, ioutil.WriteFile(metadata, []byte(`{"shop/web-7d9f": {"app": "shop"}}`), 0644))
+	later := time.Now().Add(time.Minute)
+	assert.NoError(t, os.Chtimes(metadata, later, later))
+	in.decoder.(*containerLogs).checked = time.Time{}
+	appendFile(t, path, `{"log":"next\n","stream":"stdout","time":"2019-01-02T03:04:08Z"}`+"\n")
+	events = scanFiles(in)
+	assert.Equal(t, "shop", events[0].indexed["app"])
+	assert.NotContains(t, events[0].indexed, "replicas")
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	multilineStart     string
+	multilineContinue  string
+	multilineField     string
+	multilineKey       string
+	multilineMaxLines  int
+	multilineMaxBytes  int
+	multilineTimeoutMs int
+)
+
+// multiline joins consecutive lines of the same source into one event, e.g. the frames of a stack trace.
+// A line matching start always starts a new event. Otherwise it continues the pending event of its source, provided it
+// matches continuation when one is given. Pending events are emitted when a new one starts, when they reach maxLines or maxBytes, or after timeout.
+// A line which would make the pending event longer than maxBytes starts a new one instead, and lines longer than maxBytes
+// are truncated and marked by the -truncatedField indexed field.
+type multiline struct {
+	start        *regexp.Regexp
+	continuation *regexp.Regexp
+	field        string
+	key          string
+	maxLines     int
+	maxBytes     int
+	timeout      time.Duration
+	groups       map[string]*multilineGroup
+	now          func() time.Time
+}
+
+type multilineGroup struct {
+	first     *logEvent
+	lines     []string
+	bytes     int // length of the lines joined by newlines
+	truncated bool
+	updated   time.Time
+}
+
+func newMultiline(start, continuation, field, key string, maxLines, maxBytes int, timeout time.Duration) (*multiline, error) {
+	if start == "" && continuation == "" {
+		return nil, fmt.Errorf("multiline requires a start or continuation pattern")
+	}
+	if maxLines < 1 || maxBytes < 1 || timeout <= 0 {
+		return nil, fmt.Errorf("multiline max lines, max bytes and timeout must be positive")
+	}
+	m := &multiline{
+		field:    field,
+		key:      key,
+		maxLines: maxLines,
+		maxBytes: maxBytes,
+		timeout:  timeout,
+		groups:   map[string]*multilineGroup{},
+		now:      time.Now,
+	}
+	var err error
+	if start != "" {
+		if m.start, err = regexp.Compile(start); err != nil {
+			return nil, fmt.Errorf("multiline start: %v", err)
+		}
+	}
+	if continuation != "" {
+		if m.continuation, err = regexp.Compile(continuation); err != nil {
+			return nil, fmt.Errorf("multiline continuation: %v", err)
+		}
+	}
+	return m, nil
+}
+
+func (m *multiline) process(e *logEvent, emit func(*logEvent)) {
+	key := m.source(e)
+	text := m.text(e)
+	continues := m.continues(text)
+	truncated := len(text) > m.maxBytes
+	if truncated {
+		text = truncateString(text, m.maxBytes)
+	}
+	g, pending := m.groups[key]
+	if pending && continues && g.bytes+1+len(text) <= m.maxBytes {
+		g.lines = append(g.lines, text)
+		g.bytes += 1 + len(text)
+		g.truncated = g.truncated || truncated
+		g.first.acks = append(g.first.acks, e.acks...) // the line is delivered with the event it continues
+		e.acks = nil
+		g.updated = m.now()
+		if len(g.lines) >= m.maxLines || g.bytes >= m.maxBytes {
+			m.release(key, emit)
+		}
+		return
+	}
+	if pending {
+		m.release(key, emit)
+	}
+	m.groups[key] = &multilineGroup{first: e, lines: []string{text}, bytes: len(text), truncated: truncated, updated: m.now()}
+	if m.maxLines == 1 || len(text) >= m.maxBytes {
+		m.release(key, emit)
+	}
+}
+
+// tick emits the pending events which have not been continued within the timeout
+func (m *multiline) tick(now time.Time, emit func(*logEvent)) {
+	for _, key := range m.keys() {
+		if now.Sub(m.groups[key].updated) >= m.timeout {
+			m.release(key, emit)
+		}
+	}
+}
+
+func (m *multiline) flush(emit func(*logEvent)) {
+	for _, key := range m.keys() {
+		m.release(key, emit)
+	}
+}
+
+func (m *multiline) keys() []string {
+	keys := make([]string, 0, len(m.groups))
+	for key := range m.groups {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (m *multiline) continues(text string) bool {
+	if m.start != nil && m.start.MatchString(text) {
+		return false
+	}
+	return m.continuation == nil || m.continuation.MatchString(text)
+}
+
+func (m *multiline) source(e *logEvent) string {
+	if m.key != "" {
+		key, _ := e.Field(m.key)
+		return key
+	}
+	return e.source
+}
+
+func (m *multiline) text(e *logEvent) string {
+	if m.field != "" {
+		if text, found := e.Field(m.field); found {
+			return text
+		}
+	}
+	return strings.TrimRight(e.raw, "\r\n")
+}
+
+// release emits the pending event of a source with the text of all its lines
+func (m *multiline) release(key string, emit func(*logEvent)) {
+	g := m.groups[key]
+	delete(m.groups, key)
+	e := g.first
+	if len(g.lines) > 1 || g.truncated {
+		text := strings.Join(g.lines, "\n")
+		if _, found := e.Fields()[m.field]; m.field != "" && found {
+			e.fields[m.field] = text
+			e.modified = true
+		} else {
+			e.raw = text + e.raw[len(strings.TrimRight(e.raw, "\r\n")):]
+			e.fields, e.parsed, e.modified = nil, false, false
+		}
+	}
+	if g.truncated && truncatedField != "" {
+		if e.indexed == nil {
+			e.indexed = map[string]interface{}{}
+		}
+		e.indexed[truncatedField] = "true"
+	}
+	emit(e)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func journaldLine(unit, message string) string {
+	return fmt.Sprintf(`{"SYSTEMD_UNIT":%v,"MESSAGE":%v}`, jsonString(unit), jsonString(message))
+}
+
+func Test_Multiline_JoinsStackTracesPerSource(t *testing.T) {
+	defer withJournald("", false)()
+	m, err := newMultiline("", `^\s+at |^Caused by:`, "MESSAGE", "", 500, 65536, time.Second)
+	assert.NoError(t, err)
+
+	emitted := []*logEvent{}
+	emit := func(e *logEvent) { emitted = append(emitted, e) }
+	for _, line := range []string{
+		journaldLine("content-api@1.service", "java.lang.IllegalStateException: boom"),
+		journaldLine("content-api@1.service", "    at com.ft.Api.get(Api.java:42)"),
+		journaldLine("annotations-mapper@2.service", "Successfully mapped"),
+		journaldLine("content-api@1.service", "    at com.ft.Main.main(Main.java:7)"),
+		journaldLine("content-api@1.service", "Caused by: java.io.IOException: closed"),
+		journaldLine("content-api@1.service", "Request served"),
+	} {
+		m.process(newLogEvent(line), emit)
+	}
+	assert.Len(t, emitted, 1)
+	m.flush(emit)
+	assert.Len(t, emitted, 3)
+
+	assert.Equal(t, "java.lang.IllegalStateException: boom\n    at com.ft.Api.get(Api.java:42)\n    at com.ft.Main.main(Main.java:7)\nCaused by: java.io.IOException: closed", emitted[0].fields["MESSAGE"])
+	assert.Equal(t, "annotations-mapper@2.service", emitted[1].source)
+	assert.Equal(t, "Successfully mapped", emitted[1].fields["MESSAGE"])
+	assert.Equal(t, "Request served", emitted[2].fields["MESSAGE"])
+}
+
+func Test_Multiline_StartPatternOnRawLines(t *testing.T) {
+	m, err := newMultiline(`^\d{4}-\d{2}-\d{2} `, "", "", "", 3, 65536, time.Second)
+	assert.NoError(t, err)
+
+	emitted := []string{}
+	emit := func(e *logEvent) { emitted = append(emitted, e.raw) }
+	for _, line := range []string{
+		"2017-08-18 14:37:15 ERROR failed\n",
+		"  detail 1\n",
+		"  detail 2\n",
+		"  detail 3\n",
+		"2017-08-18 14:37:16 INFO ok\n",
+	} {
+		m.process(newLogEvent(line), emit)
+	}
+	m.flush(emit)
+	assert.Equal(t, []string{
+		"2017-08-18 14:37:15 ERROR failed\n  detail 1\n  detail 2\n",
+		"  detail 3\n",
+		"2017-08-18 14:37:16 INFO ok\n",
+	}, emitted)
+}
+
+func Test_Multiline_FlushesAfterTimeoutAndMaxBytes(t *testing.T) {
+	m, err := newMultiline("", `^\s`, "", "", 500, 20, time.Second)
+	assert.NoError(t, err)
+	start := time.Date(2017, time.August, 18, 14, 37, 15, 0, time.UTC)
+	clock := &rateLimitClock{start}
+	m.now = clock.now
+
+	emitted := []string{}
+	emit := func(e *logEvent) { emitted = append(emitted, e.raw) }
+	m.process(newLogEvent("panic: boom\n"), emit)
+	m.tick(start.Add(500*time.Millisecond), emit)
+	assert.Empty(t, emitted)
+	m.tick(start.Add(time.Second), emit)
+	assert.Equal(t, []string{"panic: boom\n"}, emitted)
+
+	events := []*logEvent{}
+	emit = func(e *logEvent) { events = append(events, e) }
+	for _, line := range []string{"panic: boom", "  at a", "  at b", "  goroutine 1 [running]", "  at c"} {
+		m.process(newLogEvent(line), emit)
+	}
+	m.flush(emit)
+	emitted = []string{}
+	for _, e := range events {
+		assert.True(t, len(e.raw) <= 20, "event %q is longer than the max bytes", e.raw)
+		emitted = append(emitted, e.raw)
+	}
+	assert.Equal(t, []string{"panic: boom\n  at a", "  at b", "  goroutine 1 [runni", "  at c"}, emitted)
+	assert.Nil(t, events[0].indexed)
+	assert.Equal(t, "true", events[2].indexed[truncatedField])
+}
+
+func Test_Multiline_StartAndContinuationPatterns(t *testing.T) {
+	m, err := newMultiline(`^\d{4}-`, `^\s`, "", "", 500, 65536, time.Second)
+	assert.NoError(t, err)
+
+	emitted := []string{}
+	emit := func(e *logEvent) { emitted = append(emitted, e.raw) }
+	for _, line := range []string{"2017-08-18 ERROR failed", "  detail", "2017-08-18 INFO ok", "unrelated", "  detail"} {
+		m.process(newLogEvent(line), emit)
+	}
+	m.flush(emit)
+	assert.Equal(t, []string{"2017-08-18 ERROR failed\n  detail", "2017-08-18 INFO ok", "unrelated\n  detail"}, emitted)
+
+	m, err = newMultiline(`^\s*\d{4}-`, `^\s`, "", "", 500, 65536, time.Second)
+	assert.NoError(t, err)
+	emitted = []string{}
+	m.process(newLogEvent("2017-08-18 ERROR failed"), emit)
+	m.process(newLogEvent(" 2017-08-18 INFO ok"), emit)
+	m.flush(emit)
+	assert.Len(t, emitted, 2, "lines matching the start pattern start an event even when they match the continuation")
+}
+
+func Test_Multiline_TruncatesOnCharacterBoundaries(t *testing.T) {
+	m, err := newMultiline("", `^\s`, "", "", 500, 3, time.Second)
+	assert.NoError(t, err)
+
+	emitted := []string{}
+	emit := func(e *logEvent) { emitted = append(emitted, e.raw) }
+	m.process(newLogEvent("naïveté"), emit)
+	m.flush(emit)
+	assert.Equal(t, []string{"na"}, emitted, "the line is not cut within ï")
+}
+
+func Test_NewMultiline_Invalid(t *testing.T) {
+	_, err := newMultiline("", "", "", "", 500, 65536, time.Second)
+	assert.Error(t, err)
+	_, err = newMultiline("(", "", "", "", 500, 65536, time.Second)
+	assert.Error(t, err)
+	_, err = newMultiline("", "^\\s", "", "", 0, 65536, time.Second)
+	assert.Error(t, err)
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	outputSplunk     = "splunk"
+	outputOpenSearch = "opensearch"
+)
+
+var (
+	outputType    string
+	indexTemplate string
+)
+
+// indexNames names the index of a document after the time of its event: the Go time layouts in braces are replaced by
+// the event time in UTC, e.g. logs-{2006.01.02} becomes logs-2015.04.21
+type indexNames struct {
+	literals []string // the text around the layouts, one more than the layouts
+	layouts  []string
+}
+
+func parseIndexTemplate(template string) (indexNames, error) {
+	names := indexNames{}
+	if template == "" {
+		return names, fmt.Errorf("index template is empty")
+	}
+	rest := template
+	for {
+		start := strings.IndexAny(rest, "{}")
+		if start < 0 {
+			names.literals = append(names.literals, rest)
+			return names, nil
+		}
+		end := strings.Index(rest[start:], "}")
+		if rest[start] == '}' || end < 0 {
+			return names, fmt.Errorf("index template %q has unbalanced braces", template)
+		}
+		layout := rest[start+1 : start+end]
+		if layout == "" || strings.Contains(layout, "{") {
+			return names, fmt.Errorf("index template %q has an invalid time layout %q", template, layout)
+		}
+		names.literals = append(names.literals, rest[:start])
+		names.layouts = append(names.layouts, layout)
+		rest = rest[start+end+1:]
+	}
+}
+
+func (names indexNames) index(t time.Time) string {
+	parts := []string{names.literals[0]}
+	for i, layout := range names.layouts {
+		parts = append(parts, t.UTC().Format(layout), names.literals[i+1])
+	}
+	return strings.Join(parts, "")
+}
+
+// bulkURL points the url of an OpenSearch or Elasticsearch cluster to its bulk API. Credentials in the url are returned
+// apart, so that they are sent as basic auth without being logged with the url.
+func bulkURL(base string) (string, *url.Userinfo, error) {
+	u, err := url.Parse(base)
+	if err != nil {
+		return "", nil, err
+	}
+	user := u.User
+	u.User = nil
+	if path := strings.TrimSuffix(u.Path, "/"); !strings.HasSuffix(path, "/_bulk") {
+		u.Path = path + "/_bulk"
+	}
+	return u.String(), user, nil
+}
+
+// bulkDocument is the document indexed for an event: its json fields, or its raw line as message, with the event time as
+// @timestamp. The HEC metadata, indexed and metric fields are added unless the event has fields of the same names.
+func (e *logEvent) bulkDocument() map[string]interface{} {
+	doc := map[string]interface{}{}
+	if fields := e.Fields(); fields != nil {
+		for k, v := range fields {
+			doc[k] = v
+		}
+	} else {
+		doc["message"] = strings.TrimRight(e.raw, "\n")
+	}
+	add := func(k string, v interface{}) {
+		if _, found := doc[k]; !found {
+			doc[k] = v
+		}
+	}
+	add("@timestamp", e.time.UTC().Format(time.RFC3339Nano))
+	for k, v := range map[string]string{"host": e.host, "source": e.source, "sourcetype": e.sourcetype} {
+		if v != "" {
+			add(k, v)
+		}
+	}
+	for k, v := range e.indexed {
+		add(k, v)
+	}
+	for k, v := range e.metric {
+		add(k, v)
+	}
+	return doc
+}
+
+// writeBulk produces the NDJSON body of a bulk request indexing every event into the index named after its time
+func writeBulk(eventlist []*logEvent, names indexNames) string {
+	buf := &bytes.Buffer{}
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	for _, e := range eventlist {
+		e.decorate()
+		action := map[string]interface{}{"index": map[string]string{"_index": names.index(e.time)}}
+		mark := buf.Len()
+		enc.Encode(action)
+		if err := enc.Encode(e.bulkDocument()); err != nil {
+			log.Printf("Dropping event which can not be indexed: %v\n", err)
+			buf.Truncate(mark)
+		}
+	}
+	return buf.String()
+}
+
+// bulkResponse holds the result of every action of a bulk request, in the order of the request
+type bulkResponse struct {
+	Errors bool                  `json:"errors"`
+	Items  []map[string]bulkItem `json:"items"`
+}
+
+type bulkItem struct {
+	Status int `json:"status"`
+	Error  *struct {
+		Type   string `json:"type"`
+		Reason string `json:"reason"`
+	} `json:"error"`
+}
+
+// bulkFailures reads the response to a bulk request. The documents which failed for the time being, i.e. rejected
+// with 429 or a server error, are returned as a bulk payload to retry. The other failures, e.g. mapping errors, would
+// fail again and are returned as the reasons the documents were rejected.
+func bulkFailures(body io.Reader, payload string) (string, []string, error) {
+	response := bulkResponse{}
+	if err := json.NewDecoder(body).Decode(&response); err != nil {
+		return "", nil, err
+	}
+	if !response.Errors {
+		return "", nil, nil
+	}
+	lines := strings.Split(strings.TrimSuffix(payload, "\n"), "\n")
+	if len(lines) != 2*len(response.Items) {
+		return "", nil, fmt.Errorf("%v results for %v documents", len(response.Items), len(lines)/2)
+	}
+	retry := []string{}
+	rejected := []string{}
+	for i, item := range response.Items {
+		for _, result := range item {
+			switch {
+			case result.Status < 300:
+			case result.Status == 429 || result.Status >= 500:
+				retry = append(retry, lines[2*i], lines[2*i+1])
+			case result.Error != nil:
+				rejected = append(rejected, fmt.Sprintf("%v: %v", result.Error.Type, result.Error.Reason))
+			default:
+				rejected = append(rejected, fmt.Sprintf("status %v", result.Status))
+			}
+		}
+	}
+	if len(retry) == 0 {
+		return "", rejected, nil
+	}
+	return strings.Join(retry, "\n") + "\n", rejected, nil
+}
+
+// bulkResult caches the documents of an accepted bulk request which failed for the time being, and drops the rejected
+// ones. It tells whether failed documents were cached.
+func (out *output) bulkResult(body io.Reader, payload string) (bool, error) {
+	retry, rejected, err := bulkFailures(body, payload)
+	if err != nil {
+		log.Printf("Unreadable bulk response from %v, assuming the documents were indexed: %v\n", out.url, err)
+		return false, nil
+	}
+	if len(rejected) > 0 {
+		out.bulkRejected.Inc(int64(len(rejected)))
+		log.Printf("%v rejected %v documents, e.g. %v\n", out.url, len(rejected), rejected[0])
+	}
+	if retry == "" {
+		return false, nil
+	}
+	failed := strings.Count(retry, "\n") / 2
+	out.bulkFailed.Inc(int64(failed))
+	return out.cacheForRetry(retry), fmt.Errorf("%v documents failed to be indexed", failed)
+}
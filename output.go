@@ -0,0 +1,340 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+// outputConfig holds the settings of an output, named like the flags setting them for the default output
+type outputConfig struct {
+	url           string
+	token         string
+	workers       int
+	buffer        int
+	batchsize     int
+	batchtimer    int
+	dryrun        bool
+	hecMode       string
+	channel       string
+	rawSourcetype string
+	rawIndex      string
+	bucket        string
+	awsRegion     string
+	delivery      string
+	kind          string
+	indexTemplate string
+}
+
+// outputConfigFromFlags returns the settings of the default output
+func outputConfigFromFlags() outputConfig {
+	return outputConfig{fwdURL, token, workers, chanBuffer, batchsize, batchtimer, dryrun, hecMode, channel, rawSourcetype,
+		rawIndex, bucket, awsRegion, deliveryBlocking, outputType, indexTemplate}
+}
+
+// flagSet registers the settings as flags, so that they can be read from a file like the flags of the config file
+func (c *outputConfig) flagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet("output", flag.ContinueOnError)
+	fs.StringVar(&c.url, "url", c.url, "")
+	fs.StringVar(&c.token, "token", c.token, "")
+	fs.IntVar(&c.workers, "workers", c.workers, "")
+	fs.IntVar(&c.buffer, "buffer", c.buffer, "")
+	fs.IntVar(&c.batchsize, "batchsize", c.batchsize, "")
+	fs.IntVar(&c.batchtimer, "batchtimer", c.batchtimer, "")
+	fs.BoolVar(&c.dryrun, "dryrun", c.dryrun, "")
+	fs.StringVar(&c.hecMode, "hecMode", c.hecMode, "")
+	fs.StringVar(&c.channel, "channel", c.channel, "")
+	fs.StringVar(&c.rawSourcetype, "rawSourcetype", c.rawSourcetype, "")
+	fs.StringVar(&c.rawIndex, "rawIndex", c.rawIndex, "")
+	fs.StringVar(&c.bucket, "bucketName", c.bucket, "")
+	fs.StringVar(&c.awsRegion, "awsRegion", c.awsRegion, "")
+	fs.StringVar(&c.delivery, "delivery", c.delivery, "")
+	fs.StringVar(&c.kind, "outputType", c.kind, "")
+	fs.StringVar(&c.indexTemplate, "indexTemplate", c.indexTemplate, "")
+	return fs
+}
+
+const (
+	// defaultOutput is the name of the output configured by the flags
+	defaultOutput = "default"
+	// deliveryBlocking outputs hold their pipeline up while their queue is full, deliveryBestEffort outputs drop the
+	// batches which do not fit in their queue instead
+	deliveryBlocking   = "blocking"
+	deliveryBestEffort = "besteffort"
+)
+
+// output posts batches to a Splunk HEC endpoint, or to the bulk API of OpenSearch, from its own queue and workers. It
+// keeps its own health, which paces the retries of the batches it cached in its S3 bucket, so a slow or failing output
+// does not affect the others unless its delivery is blocking and its queue is full.
+type output struct {
+	name         string
+	kind         string
+	url          string
+	user         *url.Userinfo // basic auth of opensearch outputs
+	token        string
+	hecMode      string
+	index        indexNames
+	workers      int
+	batchsize    int
+	batchtimer   time.Duration
+	dryrun       bool
+	bestEffort   bool
+	queue        chan batch
+	running      sync.WaitGroup
+	status       *serviceStatus
+	retry        Retry
+	requests     metrics.Counter
+	errors       metrics.Counter
+	postTime     metrics.Timer
+	latency      metrics.Timer
+	queueLen     metrics.Histogram
+	dropped      metrics.Counter
+	healthy      metrics.Gauge
+	bulkFailed   metrics.Counter // documents of opensearch outputs cached for retry
+	bulkRejected metrics.Counter // documents of opensearch outputs rejected for good
+}
+
+// newOutput creates an output whose metrics are named after it. The metrics of the default output keep the names they
+// had before outputs were named.
+func newOutput(name string, c outputConfig) (*output, error) {
+	if c.url == "" {
+		return nil, fmt.Errorf("output %v requires a url", name)
+	}
+	if c.bucket == "" {
+		return nil, fmt.Errorf("output %v requires a bucketName to cache failed batches", name)
+	}
+	if c.workers < 1 || c.buffer < 0 || c.batchsize < 1 || c.batchtimer < 1 {
+		return nil, fmt.Errorf("output %v requires positive workers, batchsize and batchtimer", name)
+	}
+	if c.delivery != deliveryBlocking && c.delivery != deliveryBestEffort {
+		return nil, fmt.Errorf("output %v: unknown delivery %q, expected %v or %v", name, c.delivery, deliveryBlocking, deliveryBestEffort)
+	}
+	var endpoint string
+	var user *url.Userinfo
+	var index indexNames
+	var err error
+	switch c.kind {
+	case outputSplunk:
+		if c.token == "" {
+			return nil, fmt.Errorf("output %v requires a token", name)
+		}
+		if c.hecMode == hecModeRaw && len(metricFormats) > 0 {
+			return nil, fmt.Errorf("output %v: metric events can not be sent in %v mode", name, hecModeRaw)
+		}
+		var channel string
+		if endpoint, channel, err = hecEndpoint(c.url, c.hecMode, c.channel, c.rawSourcetype, c.rawIndex, hostname); err != nil {
+			return nil, fmt.Errorf("output %v: %v", name, err)
+		}
+		if channel != c.channel {
+			log.Printf("Output %v sends raw batches on channel %v\n", name, channel)
+		}
+	case outputOpenSearch:
+		if index, err = parseIndexTemplate(c.indexTemplate); err != nil {
+			return nil, fmt.Errorf("output %v: %v", name, err)
+		}
+		if endpoint, user, err = bulkURL(c.url); err != nil {
+			return nil, fmt.Errorf("output %v: %v", name, err)
+		}
+	default:
+		return nil, fmt.Errorf("output %v: unknown output type %q, expected %v or %v", name, c.kind, outputSplunk, outputOpenSearch)
+	}
+	// metrics are named after the output, e.g. output.audit.splunk_healthy, and its type, e.g. opensearch_healthy
+	prefix := ""
+	if name != defaultOutput {
+		prefix = "output." + name + "."
+	}
+	service := prefix + c.kind
+	out := &output{
+		name:       name,
+		kind:       c.kind,
+		url:        endpoint,
+		user:       user,
+		token:      c.token,
+		hecMode:    c.hecMode,
+		index:      index,
+		workers:    c.workers,
+		batchsize:  c.batchsize,
+		batchtimer: time.Duration(c.batchtimer) * time.Second,
+		dryrun:     c.dryrun,
+		bestEffort: c.delivery == deliveryBestEffort,
+		queue:      make(chan batch, c.buffer),
+		status:     &serviceStatus{healthy: false, timestamp: time.Now()},
+		requests:   metrics.GetOrRegisterCounter(service+"_requests_total", metrics.DefaultRegistry),
+		errors:     metrics.GetOrRegisterCounter(service+"_requests_error", metrics.DefaultRegistry),
+		postTime:   metrics.GetOrRegisterTimer(prefix+"post.time", metrics.DefaultRegistry),
+		latency:    metrics.GetOrRegisterTimer(prefix+"post.queue.latency", metrics.DefaultRegistry),
+		queueLen:   metrics.GetOrRegisterHistogram(prefix+"post.queue.length", metrics.DefaultRegistry, metrics.NewExpDecaySample(1024, 0.015)),
+		dropped:    metrics.GetOrRegisterCounter(prefix+"post.dropped", metrics.DefaultRegistry),
+		healthy:    metrics.GetOrRegisterGauge(service+"_healthy", metrics.DefaultRegistry),
+	}
+	if c.kind == outputOpenSearch {
+		out.bulkFailed = metrics.GetOrRegisterCounter(prefix+"bulk.failed", metrics.DefaultRegistry)
+		out.bulkRejected = metrics.GetOrRegisterCounter(prefix+"bulk.rejected", metrics.DefaultRegistry)
+	}
+	out.retry = NewRetry(out.postToSplunk, out.isHealthy, c.bucket, c.awsRegion)
+	return out, nil
+}
+
+// start runs the workers posting the queued batches and the retry of the cached ones
+func (out *output) start() {
+	go out.queueLenMetrics()
+	for i := 0; i < out.workers; i++ {
+		out.running.Add(1)
+		go func() {
+			defer out.running.Done()
+			for b := range out.queue {
+				if out.dryrun {
+					log.Printf("Dryrun enabled, not posting to %v\n", out.url)
+					b.ack()
+				} else if out.postOrCache(b.payload) == nil {
+					b.ack()
+				}
+			}
+		}()
+	}
+	out.retry.Start()
+}
+
+// stop waits for the queued batches to be posted. Nothing may be written to the output afterwards.
+func (out *output) stop() {
+	close(out.queue)
+	out.running.Wait()
+}
+
+func (out *output) queueLenMetrics() {
+	for {
+		time.Sleep(200 * time.Millisecond)
+		out.queueLen.Update(int64(len(out.queue)))
+	}
+}
+
+// write queues the events as a batch acknowledged once delivered or cached for retry. It waits while the queue is full,
+// unless the delivery is best effort: the batch is then dropped and counted.
+func (out *output) write(eventlist []*logEvent, acks []func()) {
+	if len(eventlist) == 0 { //only attempt delivery if eventlist contains elements
+		return
+	}
+	payload := ""
+	if out.kind == outputOpenSearch {
+		payload = writeBulk(eventlist, out.index)
+	} else {
+		payload = writePayload(eventlist, out.hecMode)
+	}
+	b := batch{payload: payload, acks: acks}
+	if out.bestEffort {
+		select {
+		case out.queue <- b:
+		default:
+			out.dropped.Inc(int64(len(eventlist)))
+		}
+		return
+	}
+	out.latency.Time(func() {
+		out.queue <- b
+	})
+}
+
+func (out *output) postToSplunk(s string) error {
+	_, err := out.post(s)
+	return err
+}
+
+// postOrCache posts to Splunk, caching the payload for retry on failure. It returns nil once the payload is safe, i.e.
+// accepted by Splunk or cached.
+func (out *output) postOrCache(s string) error {
+	cached, err := out.post(s)
+	if err != nil && cached {
+		return nil
+	}
+	return err
+}
+
+// post sends a payload to Splunk and caches it for retry if that fails. It tells whether the payload was cached. Bulk
+// requests accepted by OpenSearch only cache their failed documents.
+func (out *output) post(s string) (bool, error) {
+	var err error
+	cached := false
+	out.postTime.Time(func() {
+		var req *http.Request
+		var r *http.Response
+		req, err = http.NewRequest("POST", out.url, strings.NewReader(s))
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		out.authorize(req)
+		out.requests.Inc(1)
+		r, err = client.Do(req)
+		timestamp := time.Now()
+		if err != nil {
+			out.errors.Inc(1)
+			log.Println(err)
+			cached = out.cacheForRetry(s)
+			out.setHealthy(false, timestamp)
+		} else {
+			defer r.Body.Close()
+			defer io.Copy(ioutil.Discard, r.Body)
+			if r.StatusCode != 200 {
+				err = errors.New(r.Status)
+				out.errors.Inc(1)
+				out.setHealthy(false, timestamp)
+				log.Printf("Unexpected status code %v (%v) when sending %v to %v\n", r.StatusCode, r.Status, s, out.url)
+				cached = out.cacheForRetry(s)
+			} else {
+				out.setHealthy(true, timestamp)
+				if out.kind == outputOpenSearch {
+					cached, err = out.bulkResult(r.Body, s)
+				}
+			}
+		}
+	})
+	return cached, err
+}
+
+// authorize adds the Splunk token to a request, or for OpenSearch the basic auth of the url, else the token as API key
+func (out *output) authorize(req *http.Request) {
+	if out.kind == outputSplunk {
+		tokenWithKeyword := strings.Join([]string{"Splunk", out.token}, " ") //join strings "Splunk" and the output token
+		req.Header.Set("Authorization", tokenWithKeyword)
+		return
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	if out.user != nil {
+		password, _ := out.user.Password()
+		req.SetBasicAuth(out.user.Username(), password)
+	} else if out.token != "" {
+		req.Header.Set("Authorization", "ApiKey "+out.token)
+	}
+}
+
+func (out *output) cacheForRetry(s string) bool {
+	err := out.retry.Enqueue(s)
+	if err != nil {
+		log.Printf("Unexpected error when caching failed messages: %v\n", err)
+		return false
+	}
+	return true
+}
+
+func (out *output) setHealthy(healthy bool, timestamp time.Time) {
+	out.status.setHealthy(healthy, timestamp)
+	if healthy {
+		out.healthy.Update(1)
+	} else {
+		out.healthy.Update(0)
+	}
+}
+
+func (out *output) isHealthy() *serviceStatus {
+	return out.status
+}
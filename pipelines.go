@@ -0,0 +1,368 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const defaultPipeline = "default"
+
+var (
+	pipelinesFile string
+	pipelines     []*eventPipeline
+	outputs       []*output
+	// stageFlagsMu guards the stage flags, which are temporarily set to the settings of a pipeline to build its stages
+	stageFlagsMu sync.Mutex
+)
+
+// pipelinesConfig is a json file of named outputs, with the settings of the output flags, and of the pipelines feeding
+// them, e.g.
+//
+//	{"outputs": {"splunk": {"url": "https://splunk:8088/services/collector", "batchsize": 100}},
+//	 "pipelines": {"system": {"inputs": ["journal", "syslog"], "stages": {"dedup": true}, "output": "splunk"},
+//	               "apps": {"inputs": ["file"], "outputs": ["splunk", "archive"]}}}
+type pipelinesConfig struct {
+	Outputs   map[string]map[string]interface{} `json:"outputs"`
+	Pipelines map[string]pipelineConfig         `json:"pipelines"`
+}
+
+// pipelineConfig lists the inputs and the outputs of a pipeline, "output" being short for a single output
+type pipelineConfig struct {
+	Inputs  []string               `json:"inputs"`
+	Stages  map[string]interface{} `json:"stages"`
+	Output  string                 `json:"output"`
+	Outputs []string               `json:"outputs"`
+}
+
+// eventPipeline reads the events of its inputs through its processing stages into the batches of its outputs, each of
+// them getting a copy. Pipelines run concurrently, each with its own stages and batch, cut by the batchsize and
+// batchtimer shared by its outputs.
+type eventPipeline struct {
+	name     string
+	inputs   []namedInput
+	settings map[string]string // stage flags set for this pipeline only
+	stages   pipeline
+	outputs  []*output
+	reloads  chan bool
+	finished chan bool
+}
+
+// setupPipelines creates the pipelines of -pipelines, or a default pipeline reading -inputs through the stages
+// configured by the flags into the output configured by the flags
+func setupPipelines() error {
+	if err := checkFraming(framing, maxMessageBytes); err != nil {
+		return err
+	}
+	if pipelinesFile == "" {
+		if err := setupInputs(); err != nil {
+			return err
+		}
+		out, err := newOutput(defaultOutput, outputConfigFromFlags())
+		if err != nil {
+			return err
+		}
+		p, err := newEventPipeline(defaultPipeline, inputs, nil, []*output{out})
+		if err != nil {
+			return err
+		}
+		pipelines, outputs = []*eventPipeline{p}, []*output{out}
+		return nil
+	}
+	c, err := loadPipelines(pipelinesFile)
+	if err != nil {
+		return err
+	}
+	if pipelines, outputs, err = newPipelines(c); err != nil {
+		return fmt.Errorf("%v: %v", pipelinesFile, err)
+	}
+	return nil
+}
+
+func loadPipelines(path string) (pipelinesConfig, error) {
+	c := pipelinesConfig{}
+	f, err := os.Open(path)
+	if err != nil {
+		return c, err
+	}
+	defer f.Close()
+	d := json.NewDecoder(f)
+	d.UseNumber()
+	if err := d.Decode(&c); err != nil {
+		return c, fmt.Errorf("%v: %v", path, err)
+	}
+	return c, nil
+}
+
+// newPipelines creates the outputs and the pipelines of a config, in the order of their names
+func newPipelines(c pipelinesConfig) ([]*eventPipeline, []*output, error) {
+	if len(c.Pipelines) == 0 {
+		return nil, nil, fmt.Errorf("no pipelines configured")
+	}
+	named := map[string]*output{}
+	outs := []*output{}
+	buckets := map[string]string{}
+	for _, name := range sortedKeys(c.Outputs) {
+		oc, err := outputConfigFromSettings(c.Outputs[name])
+		if err != nil {
+			return nil, nil, fmt.Errorf("outputs.%v.%v", name, err)
+		}
+		if other, found := buckets[oc.bucket]; found {
+			return nil, nil, fmt.Errorf("outputs %v and %v must cache failed batches in different buckets", other, name)
+		}
+		buckets[oc.bucket] = name
+		out, err := newOutput(name, oc)
+		if err != nil {
+			return nil, nil, err
+		}
+		named[name] = out
+		outs = append(outs, out)
+	}
+
+	names := make([]string, 0, len(c.Pipelines))
+	for name := range c.Pipelines {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	readBy := map[string]string{}
+	inputs = []namedInput{}
+	created := []*eventPipeline{}
+	for _, name := range names {
+		pc := c.Pipelines[name]
+		outputNames := pc.Outputs
+		if pc.Output != "" {
+			outputNames = append([]string{pc.Output}, outputNames...)
+		}
+		if len(outputNames) == 0 {
+			return nil, nil, fmt.Errorf("pipelines.%v: no outputs configured", name)
+		}
+		pipelineOutputs := []*output{}
+		for _, outputName := range outputNames {
+			out, found := named[outputName]
+			if !found {
+				return nil, nil, fmt.Errorf("pipelines.%v: unknown output %q", name, outputName)
+			}
+			for _, other := range pipelineOutputs {
+				if other == out {
+					return nil, nil, fmt.Errorf("pipelines.%v: output %v is listed twice", name, outputName)
+				}
+				if other.batchsize != out.batchsize || other.batchtimer != out.batchtimer {
+					return nil, nil, fmt.Errorf("pipelines.%v: outputs %v and %v must have the same batchsize and batchtimer", name, other.name, outputName)
+				}
+			}
+			pipelineOutputs = append(pipelineOutputs, out)
+		}
+		for _, input := range pc.Inputs {
+			if other, found := readBy[input]; found {
+				return nil, nil, fmt.Errorf("pipelines %v and %v both read input %v", other, name, input)
+			}
+			readBy[input] = name
+		}
+		settings := map[string]string{}
+		for setting, v := range pc.Stages {
+			if !contains(configSections["stages"], setting) {
+				return nil, nil, fmt.Errorf("pipelines.%v.stages.%v: unknown setting", name, setting)
+			}
+			value, err := configValue(flag.Lookup(setting), v)
+			if err != nil {
+				return nil, nil, fmt.Errorf("pipelines.%v.stages.%v: %v", name, setting, err)
+			}
+			settings[setting] = value
+		}
+		in, err := newInputs(pc.Inputs)
+		if err != nil {
+			return nil, nil, fmt.Errorf("pipelines.%v: %v", name, err)
+		}
+		inputs = append(inputs, in...)
+		p, err := newEventPipeline(name, in, settings, pipelineOutputs)
+		if err != nil {
+			return nil, nil, fmt.Errorf("pipelines.%v: %v", name, err)
+		}
+		created = append(created, p)
+	}
+	return created, outs, nil
+}
+
+// outputConfigFromSettings reads the settings of an output, defaulting to the output flags
+func outputConfigFromSettings(settings map[string]interface{}) (outputConfig, error) {
+	c := outputConfigFromFlags()
+	fs := c.flagSet()
+	for name, v := range settings {
+		f := fs.Lookup(name)
+		if f == nil {
+			return c, fmt.Errorf("%v: unknown setting", name)
+		}
+		value, err := configValue(f, v)
+		if err != nil {
+			return c, fmt.Errorf("%v: %v", name, err)
+		}
+		if err := fs.Set(name, value); err != nil {
+			return c, fmt.Errorf("%v: %v", name, err)
+		}
+	}
+	return c, nil
+}
+
+func newEventPipeline(name string, inputs []namedInput, settings map[string]string, outputs []*output) (*eventPipeline, error) {
+	p := &eventPipeline{
+		name:     name,
+		inputs:   inputs,
+		settings: settings,
+		outputs:  outputs,
+		reloads:  make(chan bool),
+		finished: make(chan bool),
+	}
+	stages, err := p.newStages()
+	if err != nil {
+		return nil, err
+	}
+	p.stages = stages
+	return p, nil
+}
+
+// newStages builds the stages of the pipeline from the stage flags with its own settings applied over them. The state
+// of the stages of a named pipeline is kept in its own directory of -stateDir.
+func (p *eventPipeline) newStages() (pipeline, error) {
+	stageFlagsMu.Lock()
+	defer stageFlagsMu.Unlock()
+	settings := map[string]string{}
+	for name, value := range p.settings {
+		settings[name] = value
+	}
+	if _, found := settings["stateDir"]; !found && stateDir != "" && p.name != defaultPipeline {
+		settings["stateDir"] = filepath.Join(stateDir, p.name)
+	}
+	previous := map[string]string{}
+	defer func() {
+		for name, value := range previous {
+			flag.Set(name, value)
+		}
+	}()
+	for name, value := range settings {
+		previous[name] = flag.Lookup(name).Value.String()
+		if err := flag.Set(name, value); err != nil {
+			return nil, fmt.Errorf("stages.%v: %v", name, err)
+		}
+	}
+	return newStages()
+}
+
+// reload rebuilds the stages of the pipeline once the events they hold back are released. It returns false if the
+// pipeline is done.
+func (p *eventPipeline) reload() bool {
+	select {
+	case p.reloads <- true:
+		return true
+	case <-p.finished:
+		return false
+	}
+}
+
+// run processes the events of the inputs until all of them are done, then queues the last batch
+func (p *eventPipeline) run() {
+	defer close(p.finished)
+	batchsize, batchtimer := p.outputs[0].batchsize, p.outputs[0].batchtimer
+	eventlist := make([]*logEvent, 0, batchsize) //create eventlist slice with capacity of the output batchsize
+	emit := func(e *logEvent) {                  //stages emit the processed events into eventlist
+		eventlist = append(eventlist, e)
+	}
+	timer := time.NewTimer(batchtimer)
+	defer timer.Stop()
+	ticker := time.NewTicker(stageTickInterval)
+	defer ticker.Stop()
+	events := runInputs(p.inputs) //Inputs run in their own go routines so timers fire while waiting for input
+
+	for {
+		expired := false
+		select {
+		case <-timer.C: //trigger delivery if timer expires prior to batchsize limit is exceeded
+			log.Printf("Timer expired. Trigger delivery of pipeline %v to Splunk\n", p.name)
+			expired = true
+		case now := <-ticker.C: //let time based stages flush pending events
+			p.stages.tick(now, emit)
+		case <-p.reloads: //rebuild the processing stages, the events they hold back are released into eventlist first
+			p.stages.flush(emit)
+			next, err := p.newStages()
+			if err != nil {
+				log.Printf("Invalid processing stages of pipeline %v, keeping the current ones: %v\n", p.name, err)
+				break
+			}
+			p.stages = next
+		case e, ok := <-events:
+			if !ok { //all inputs are done: process eventlist
+				p.stages.flush(emit)
+				if len(eventlist) > 0 {
+					log.Printf("Processing %v batched messages of pipeline %v before exit", len(eventlist), p.name)
+					p.write(eventlist)
+				}
+				return
+			}
+			//Pass event through the processing stages, which append it on eventlist
+			p.stages.process(e, emit)
+		}
+		if expired || len(eventlist) >= batchsize { //Trigger delivery if batchsize is exceeded
+			p.write(eventlist)
+			eventlist = make([]*logEvent, 0, batchsize)
+			timer.Reset(batchtimer) //Reset timer after message delivery
+		}
+	}
+}
+
+// write copies a batch to every output. Its events are acknowledged once all the blocking outputs delivered or cached
+// it, or right away if all the outputs are best effort.
+func (p *eventPipeline) write(eventlist []*logEvent) {
+	if len(eventlist) == 0 {
+		return
+	}
+	acks := []func(){}
+	for _, e := range eventlist {
+		e.decorate() //decorated once for all the outputs
+		acks = append(acks, e.acks...)
+	}
+	blocking := 0
+	for _, out := range p.outputs {
+		if !out.bestEffort {
+			blocking++
+		}
+	}
+	ack := ackAfter(blocking, acks)
+	for _, out := range p.outputs {
+		if out.bestEffort {
+			out.write(eventlist, nil)
+		} else {
+			out.write(eventlist, []func(){ack})
+		}
+	}
+	if blocking == 0 {
+		batch{acks: acks}.ack()
+	}
+}
+
+// ackAfter returns an acknowledgement calling acks once it is called n times
+func ackAfter(n int, acks []func()) func() {
+	var mu sync.Mutex
+	return func() {
+		mu.Lock()
+		n--
+		done := n == 0
+		mu.Unlock()
+		if done {
+			batch{acks: acks}.ack()
+		}
+	}
+}
+
+func sortedKeys(m map[string]map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writePipelines(t *testing.T, dir, content string) pipelinesConfig {
+	path := filepath.Join(dir, "pipelines.json")
+	assert.NoError(t, ioutil.WriteFile(path, []byte(content), 0644))
+	c, err := loadPipelines(path)
+	assert.NoError(t, err)
+	return c
+}
+
+func Test_NewPipelines(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pipelines")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	defer func(fifo, socket string, in []namedInput) { fifoPath, socketPath, inputs = fifo, socket, in }(fifoPath, socketPath, inputs)
+	fifoPath, socketPath = filepath.Join(dir, "forwarder.fifo"), filepath.Join(dir, "forwarder.sock")
+
+	c := writePipelines(t, dir, `{
+		"outputs": {
+			"primary": {"batchsize": 50, "bucketName": "primary-retry"},
+			"secondary": {"url": "https://other.splunk:8088/services/collector", "hecMode": "raw", "channel": "fixed", "bucketName": "secondary-retry"}
+		},
+		"pipelines": {
+			"system": {"inputs": ["fifo"], "stages": {"enrich": "team=platform"}, "output": "primary"},
+			"apps": {"inputs": ["socket"], "output": "secondary"}
+		}
+	}`)
+	created, outs, err := newPipelines(c)
+	assert.NoError(t, err)
+	defer inputs[0].input.(*socketInput).close()
+
+	assert.Len(t, outs, 2)
+	assert.Equal(t, "primary", outs[0].name)
+	assert.Equal(t, 50, outs[0].batchsize)
+	assert.Equal(t, fwdURL, outs[0].url)
+	assert.Equal(t, batchsize, outs[1].batchsize)
+	assert.Equal(t, "https://other.splunk:8088/services/collector/raw?channel=fixed&host="+hostname, outs[1].url)
+
+	assert.Equal(t, "apps", created[0].name)
+	assert.Equal(t, outs[1], created[0].outputs[0])
+	assert.Empty(t, created[0].stages)
+	assert.Equal(t, "system", created[1].name)
+	assert.Equal(t, outs[0], created[1].outputs[0])
+	assert.Len(t, created[1].stages, 1)
+	assert.Equal(t, "", enrichFlag, "the stage flags are restored")
+	assert.Equal(t, []string{"socket", "fifo"}, []string{inputs[0].name, inputs[1].name})
+}
+
+func Test_NewPipelines_Invalid(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pipelines")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	defer func(in []namedInput) { inputs = in }(inputs)
+
+	for content, expected := range map[string]string{
+		`{"outputs": {"splunk": {}}}`:                                                                                                                "no pipelines configured",
+		`{"outputs": {"splunk": {"batch": 1}}, "pipelines": {"p": {}}}`:                                                                              "outputs.splunk.batch: unknown setting",
+		`{"outputs": {"splunk": {"batchsize": "ten"}}, "pipelines": {"p": {}}}`:                                                                      "outputs.splunk.batchsize: expected an integer",
+		`{"outputs": {"a": {}, "b": {}}, "pipelines": {"p": {}}}`:                                                                                    "outputs a and b must cache failed batches in different buckets",
+		`{"outputs": {"splunk": {"url": ""}}, "pipelines": {"p": {}}}`:                                                                               "output splunk requires a url",
+		`{"outputs": {"a": {"bucketName": "a"}, "b": {"bucketName": "b", "batchtimer": 1}}, "pipelines": {"p": {"outputs": ["a", "b"]}}}`:            "pipelines.p: outputs a and b must have the same batchsize and batchtimer",
+		`{"outputs": {"splunk": {}}, "pipelines": {"p": {"output": "other"}}}`:                                                                       `pipelines.p: unknown output "other"`,
+		`{"outputs": {"splunk": {}}, "pipelines": {"p": {"output": "splunk"}}}`:                                                                      "pipelines.p: no inputs configured",
+		`{"outputs": {"splunk": {}}, "pipelines": {"p": {"inputs": ["tcp"], "output": "splunk"}}}`:                                                   `pipelines.p: unknown input "tcp"`,
+		`{"outputs": {"splunk": {}}, "pipelines": {"a": {"inputs": ["stdin"], "output": "splunk"}, "b": {"inputs": ["stdin"], "output": "splunk"}}}`: "pipelines a and b both read input stdin",
+		`{"outputs": {"splunk": {}}, "pipelines": {"p": {"inputs": ["stdin"], "stages": {"batchsize": 1}, "output": "splunk"}}}`:                     "pipelines.p.stages.batchsize: unknown setting",
+		`{"outputs": {"splunk": {}}, "pipelines": {"p": {"inputs": ["stdin"], "stages": {"dedup": 1}, "output": "splunk"}}}`:                         "pipelines.p.stages.dedup: expected true or false",
+	} {
+		_, _, err := newPipelines(writePipelines(t, dir, content))
+		if assert.Error(t, err, content) {
+			assert.Contains(t, err.Error(), expected, content)
+		}
+	}
+}
+
+func Test_EventPipeline_BatchesIntoItsOutput(t *testing.T) {
+	c := outputConfigFromFlags()
+	c.batchsize, c.buffer, c.bucket = 2, 10, "pipeline-test"
+	out, err := newOutput("pipeline_test", c)
+	assert.NoError(t, err)
+	in := namedInput{"test", readerInput{bufio.NewReader(strings.NewReader("first\nsecond\nthird\n"))}}
+	p, err := newEventPipeline("test", []namedInput{in}, map[string]string{"enrich": "team=platform"}, []*output{out})
+	assert.NoError(t, err)
+
+	p.run()
+	assert.False(t, p.reload(), "a finished pipeline is not reloaded")
+	close(out.queue)
+	payloads := []string{}
+	for b := range out.queue {
+		payloads = append(payloads, b.payload)
+	}
+	assert.Len(t, payloads, 2)
+	assert.Contains(t, payloads[0], "second")
+	assert.Contains(t, payloads[1], "third")
+	assert.Contains(t, payloads[1], "platform")
+}
+
+func Test_EventPipeline_FansOutToOutputs(t *testing.T) {
+	primary, secondary := outputConfigFromFlags(), outputConfigFromFlags()
+	primary.batchsize, primary.buffer, primary.bucket = 2, 10, "fan-out-primary"
+	secondary.buffer, secondary.bucket, secondary.delivery, secondary.hecMode = 1, "fan-out-secondary", deliveryBestEffort, hecModeRaw
+	outs := []*output{}
+	for name, c := range map[string]outputConfig{"fan_out_primary": primary, "fan_out_secondary": secondary} {
+		out, err := newOutput(name, c)
+		assert.NoError(t, err)
+		outs = append(outs, out)
+	}
+	if outs[0].name != "fan_out_primary" {
+		outs[0], outs[1] = outs[1], outs[0]
+	}
+	dropped := outs[1].dropped.Count()
+	acked := 0
+	events := []*logEvent{}
+	for _, line := range []string{"first", "second", "third", "fourth"} {
+		e := newLogEvent(line)
+		e.acks = append(e.acks, func() { acked++ })
+		events = append(events, e)
+	}
+	p := &eventPipeline{name: "fan_out", outputs: outs}
+
+	p.write(events[:2])
+	p.write(events[2:])
+	assert.Equal(t, int64(2), outs[1].dropped.Count()-dropped, "the best effort output drops what does not fit its queue")
+	assert.Equal(t, 0, acked, "events are acknowledged by the blocking outputs")
+
+	first, second := <-outs[0].queue, <-outs[0].queue
+	assert.Contains(t, first.payload, `"event":"first"`)
+	assert.Contains(t, second.payload, `"event":"third"`)
+	assert.Equal(t, "first\nsecond", (<-outs[1].queue).payload)
+	first.ack()
+	assert.Equal(t, 2, acked)
+	second.ack()
+	assert.Equal(t, 4, acked)
+
+	// without blocking outputs, events are acknowledged once queued
+	p.outputs = outs[1:]
+	p.write(events[:1])
+	assert.Equal(t, 5, acked)
+}
+
+func Test_AckAfter(t *testing.T) {
+	acked := 0
+	ack := ackAfter(2, []func(){func() { acked++ }})
+	ack()
+	assert.Equal(t, 0, acked)
+	ack()
+	assert.Equal(t, 1, acked)
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+const (
+	rateLimitDrop      = "drop"
+	rateLimitSample    = "sample"
+	rateLimitSummarise = "summarise"
+
+	// buckets of sources idle for this long are forgotten so sources which come and go do not pile up
+	rateLimitIdle = 10 * time.Minute
+)
+
+var (
+	rateLimitField      string
+	rateLimit           float64
+	rateLimitBurst      int
+	rateLimitAction     string
+	rateLimitSampleRate float64
+	rateLimitSummary    int
+	metricNameRegex     = regexp.MustCompile("[^A-Za-z0-9_-]+")
+)
+
+// rateLimiter gives every source, identified by the value of a field, a token bucket of burst events refilled at limit
+// events per second. Events over the limit are dropped, sampled, or dropped and summarised by periodic synthetic events.
+type rateLimiter struct {
+	field      string
+	limit      float64
+	burst      float64
+	action     string
+	sampleRate float64
+	interval   time.Duration
+	buckets    map[string]*tokenBucket
+	lastReport time.Time
+	now        func() time.Time
+}
+
+type tokenBucket struct {
+	tokens     float64
+	last       time.Time
+	seen       time.Time
+	suppressed int64
+	counter    metrics.Counter
+}
+
+func newRateLimiter(field string, limit float64, burst int, action string, sampleRate float64, interval time.Duration) (*rateLimiter, error) {
+	if field == "" {
+		return nil, fmt.Errorf("rate limit requires a field identifying the source")
+	}
+	if limit <= 0 {
+		return nil, fmt.Errorf("rate limit %v must be positive", limit)
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	switch action {
+	case rateLimitDrop, rateLimitSummarise:
+	case rateLimitSample:
+		if sampleRate <= 0 || sampleRate > 1 {
+			return nil, fmt.Errorf("rate limit sample rate %v is not between 0 and 1", sampleRate)
+		}
+	default:
+		return nil, fmt.Errorf("unknown rate limit action %q, expected drop, sample or summarise", action)
+	}
+	if interval <= 0 {
+		return nil, fmt.Errorf("rate limit summary interval %v must be positive", interval)
+	}
+	return &rateLimiter{
+		field:      field,
+		limit:      limit,
+		burst:      float64(burst),
+		action:     action,
+		sampleRate: sampleRate,
+		interval:   interval,
+		buckets:    map[string]*tokenBucket{},
+		now:        time.Now,
+	}, nil
+}
+
+func (r *rateLimiter) process(e *logEvent, emit func(*logEvent)) {
+	now := r.now()
+	r.tick(now, emit)
+
+	source, _ := e.Field(r.field)
+	b, found := r.buckets[source]
+	if !found {
+		b = &tokenBucket{tokens: r.burst, last: now}
+		b.counter = metrics.GetOrRegisterCounter("ratelimit."+metricName(source)+".suppressed", metrics.DefaultRegistry)
+		r.buckets[source] = b
+	}
+	b.refill(now, r.limit, r.burst)
+	b.seen = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		emit(e)
+		return
+	}
+	if r.action == rateLimitSample && rand.Float64() < r.sampleRate {
+		if e.indexed == nil {
+			e.indexed = map[string]interface{}{}
+		}
+		e.indexed[sampleRateField] = strconv.FormatFloat(r.sampleRate, 'g', -1, 64)
+		emit(e)
+		return
+	}
+	b.suppressed++
+	b.counter.Inc(1)
+	e.done()
+}
+
+// tick reports the suppressed events once per interval and forgets idle sources
+func (r *rateLimiter) tick(now time.Time, emit func(*logEvent)) {
+	if r.lastReport.IsZero() {
+		r.lastReport = now
+	}
+	if now.Sub(r.lastReport) < r.interval {
+		return
+	}
+	r.lastReport = now
+
+	sources := make([]string, 0, len(r.buckets))
+	for source := range r.buckets {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+	for _, source := range sources {
+		b := r.buckets[source]
+		if b.suppressed > 0 && r.action == rateLimitSummarise {
+			emit(r.summary(source, b.suppressed, now))
+		}
+		b.suppressed = 0
+		if now.Sub(b.seen) >= rateLimitIdle {
+			delete(r.buckets, source)
+		}
+	}
+}
+
+func (r *rateLimiter) summary(source string, suppressed int64, now time.Time) *logEvent {
+	e := newFieldsEvent(map[string]interface{}{
+		"MESSAGE":    fmt.Sprintf("%v events suppressed from %v", suppressed, source),
+		"suppressed": suppressed,
+		"rate_limit": r.limit,
+		r.field:      source,
+	}, now)
+	e.host = hostname
+	return e
+}
+
+func (b *tokenBucket) refill(now time.Time, limit float64, burst float64) {
+	if now.After(b.last) {
+		b.tokens += now.Sub(b.last).Seconds() * limit
+		if b.tokens > burst {
+			b.tokens = burst
+		}
+		b.last = now
+	}
+}
+
+// metricName turns a source into a graphite friendly metric name segment
+func metricName(s string) string {
+	if s == "" {
+		return "unknown"
+	}
+	return metricNameRegex.ReplaceAllString(s, "_")
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/stretchr/testify/assert"
+)
+
+type rateLimitClock struct {
+	t time.Time
+}
+
+func (c *rateLimitClock) now() time.Time {
+	return c.t
+}
+
+func testRateLimiter(t *testing.T, action string, sampleRate float64) (*rateLimiter, *rateLimitClock) {
+	r, err := newRateLimiter("SYSTEMD_UNIT", 10, 5, action, sampleRate, time.Minute)
+	assert.NoError(t, err)
+	clock := &rateLimitClock{time.Date(2017, time.August, 18, 14, 37, 15, 0, time.UTC)}
+	r.now = clock.now
+	return r, clock
+}
+
+func unitEvent(unit string, i int) *logEvent {
+	return newLogEvent(fmt.Sprintf(`{"SYSTEMD_UNIT":"%v","MESSAGE":"line %v"}`, unit, i))
+}
+
+func Test_RateLimiter_IsolatesSources(t *testing.T) {
+	r, clock := testRateLimiter(t, rateLimitDrop, 0)
+	suppressed := metrics.GetOrRegisterCounter("ratelimit.crashloop_1_service.suppressed", metrics.DefaultRegistry).Count()
+
+	kept := map[string]int{}
+	emit := func(e *logEvent) {
+		unit, _ := e.Field("SYSTEMD_UNIT")
+		kept[unit]++
+	}
+	for i := 0; i < 100; i++ {
+		r.process(unitEvent("crashloop@1.service", i), emit)
+		if i%10 == 0 {
+			r.process(unitEvent("annotations-mapper@2.service", i), emit)
+		}
+		clock.t = clock.t.Add(10 * time.Millisecond)
+	}
+
+	// burst of 5 plus 10 events per second during one second
+	assert.InDelta(t, 15, kept["crashloop@1.service"], 1)
+	assert.Equal(t, 10, kept["annotations-mapper@2.service"])
+	assert.Equal(t, suppressed+int64(100-kept["crashloop@1.service"]), metrics.GetOrRegisterCounter("ratelimit.crashloop_1_service.suppressed", metrics.DefaultRegistry).Count())
+}
+
+func Test_RateLimiter_Summarises(t *testing.T) {
+	r, clock := testRateLimiter(t, rateLimitSummarise, 0)
+
+	emitted := []*logEvent{}
+	emit := func(e *logEvent) { emitted = append(emitted, e) }
+	for i := 0; i < 20; i++ {
+		r.process(unitEvent("crashloop@1.service", i), emit)
+	}
+	assert.Len(t, emitted, 5)
+
+	r.tick(clock.t.Add(30*time.Second), emit)
+	assert.Len(t, emitted, 5)
+
+	clock.t = clock.t.Add(time.Minute)
+	r.tick(clock.t, emit)
+	assert.Len(t, emitted, 6)
+	summary := emitted[5]
+	assert.Equal(t, "15 events suppressed from crashloop@1.service", summary.fields["MESSAGE"])
+	assert.Equal(t, "crashloop@1.service", summary.fields["SYSTEMD_UNIT"])
+	assert.Equal(t, clock.t, summary.time)
+
+	clock.t = clock.t.Add(time.Minute)
+	r.tick(clock.t, emit)
+	assert.Len(t, emitted, 6)
+}
+
+func Test_RateLimiter_SamplesAndForgetsIdleSources(t *testing.T) {
+	r, clock := testRateLimiter(t, rateLimitSample, 1)
+
+	emitted := []*logEvent{}
+	for i := 0; i < 20; i++ {
+		r.process(unitEvent("crashloop@1.service", i), func(e *logEvent) { emitted = append(emitted, e) })
+	}
+	assert.Len(t, emitted, 20)
+	assert.Nil(t, emitted[4].indexed)
+	assert.Equal(t, "1", emitted[5].indexed[sampleRateField])
+
+	clock.t = clock.t.Add(rateLimitIdle)
+	r.tick(clock.t, func(e *logEvent) {})
+	assert.Empty(t, r.buckets)
+}
+
+func Test_NewRateLimiter_Invalid(t *testing.T) {
+	_, err := newRateLimiter("", 10, 5, rateLimitDrop, 0, time.Minute)
+	assert.Error(t, err)
+	_, err = newRateLimiter("SYSTEMD_UNIT", 0, 5, rateLimitDrop, 0, time.Minute)
+	assert.Error(t, err)
+	_, err = newRateLimiter("SYSTEMD_UNIT", 10, 5, "block", 0, time.Minute)
+	assert.Error(t, err)
+	_, err = newRateLimiter("SYSTEMD_UNIT", 10, 5, rateLimitSample, 0, time.Minute)
+	assert.Error(t, err)
+	_, err = newRateLimiter("SYSTEMD_UNIT", 10, 5, rateLimitSummarise, 0, 0)
+	assert.Error(t, err)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/pborman/uuid"
+)
+
+const (
+	hecModeEvent = "event"
+	hecModeRaw   = "raw"
+)
+
+var (
+	hecMode       string
+	channel       string
+	rawSourcetype string
+	rawIndex      string
+)
+
+// hecEndpoint returns the endpoint batches are posted to in a HEC mode, and the channel used in raw mode. A channel is
+// generated if none is given.
+func hecEndpoint(base, mode, channel, sourcetype, index, host string) (string, string, error) {
+	switch mode {
+	case hecModeEvent:
+		return base, channel, nil
+	case hecModeRaw:
+		if channel == "" {
+			channel = uuid.New()
+		}
+		endpoint, err := rawURL(base, url.Values{
+			"channel":    {channel},
+			"sourcetype": {sourcetype},
+			"index":      {index},
+			"host":       {host},
+		})
+		return endpoint, channel, err
+	}
+	return "", "", fmt.Errorf("unknown HEC mode %q, expected %v or %v", mode, hecModeEvent, hecModeRaw)
+}
+
+// rawURL points the HEC url to the raw endpoint and adds the non empty query parameters
+func rawURL(base string, params url.Values) (string, error) {
+	u, err := url.Parse(base)
+	if err != nil {
+		return "", err
+	}
+	path := strings.TrimSuffix(u.Path, "/")
+	if strings.HasSuffix(path, "/services/collector/event") {
+		path = strings.TrimSuffix(path, "/event")
+	}
+	if strings.HasSuffix(path, "/services/collector") {
+		path = path + "/raw"
+	}
+	u.Path = path
+
+	query := u.Query()
+	for k, values := range params {
+		for _, v := range values {
+			if v != "" {
+				query.Add(k, v)
+			}
+		}
+	}
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// writeRaw produces a newline separated batch for the HEC raw endpoint, leaving line breaking and timestamping to Splunk
+func writeRaw(eventlist []*logEvent) string {
+	lines := make([]string, 0, len(eventlist))
+	for _, e := range eventlist {
+		lines = append(lines, e.rawBody())
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (e *logEvent) rawBody() string {
+	if e.modified {
+		body, err := json.Marshal(e.fields)
+		if err != nil { // the raw line may hold values redacted from the fields, it is never sent instead of them
+			return fmt.Sprint(e.fields)
+		}
+		return string(body)
+	}
+	return strings.TrimRight(e.raw, "\r\n")
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_RawURL(t *testing.T) {
+	params := url.Values{"channel": {"c0ffee"}, "sourcetype": {"access_combined"}, "index": {""}}
+	for base, expected := range map[string]string{
+		"https://splunk.ft.com/services/collector/event":       "https://splunk.ft.com/services/collector/raw?channel=c0ffee&sourcetype=access_combined",
+		"https://splunk.ft.com/services/collector/":            "https://splunk.ft.com/services/collector/raw?channel=c0ffee&sourcetype=access_combined",
+		"https://splunk.ft.com/services/collector/raw":         "https://splunk.ft.com/services/collector/raw?channel=c0ffee&sourcetype=access_combined",
+		"https://splunk.ft.com/proxy/raw?token=abc":            "https://splunk.ft.com/proxy/raw?channel=c0ffee&sourcetype=access_combined&token=abc",
+		"https://splunk.ft.com:8088/services/collector/event/": "https://splunk.ft.com:8088/services/collector/raw?channel=c0ffee&sourcetype=access_combined",
+	} {
+		actual, err := rawURL(base, params)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, actual, base)
+	}
+}
+
+func Test_HECEndpoint(t *testing.T) {
+	base := "https://splunk.ft.com/services/collector/event"
+
+	endpoint, channel, err := hecEndpoint(base, hecModeEvent, "", "", "", "test_host")
+	assert.NoError(t, err)
+	assert.Equal(t, base, endpoint)
+	assert.Empty(t, channel)
+
+	endpoint, channel, err = hecEndpoint(base, hecModeRaw, "", "access_combined", "upp", "test_host")
+	assert.NoError(t, err)
+	assert.NotEmpty(t, channel)
+	assert.Equal(t, "https://splunk.ft.com/services/collector/raw?channel="+channel+"&host=test_host&index=upp&sourcetype=access_combined", endpoint)
+
+	endpoint, _, err = hecEndpoint(base, hecModeRaw, "fixed", "", "", "")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://splunk.ft.com/services/collector/raw?channel=fixed", endpoint)
+
+	_, _, err = hecEndpoint(base, "metrics", "", "", "", "")
+	assert.Error(t, err)
+}
+
+func Test_WriteRaw(t *testing.T) {
+	defer withJournald("", true)()
+
+	actual := writeRaw([]*logEvent{
+		newLogEvent("127.0.0.1 - - [21/Apr/2015:12:15:34 +0000] \"GET / HTTP/1.1\" 200 53706\n"),
+		newLogEvent(`{"HOSTNAME":"test_host","MESSAGE":"Successfully mapped"}` + "\n"),
+	})
+	assert.Equal(t, "127.0.0.1 - - [21/Apr/2015:12:15:34 +0000] \"GET / HTTP/1.1\" 200 53706\n"+`{"MESSAGE":"Successfully mapped"}`, actual)
+}
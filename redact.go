@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+const (
+	redactMask = "mask"
+	redactHash = "hash"
+	redactDrop = "drop"
+
+	defaultMask = "****"
+)
+
+var (
+	redactBuiltins  string
+	redactRulesFile string
+	redactKey       string
+	// builtinRedactPatterns can be referred to by name. When a pattern has a capture group only the group is redacted.
+	builtinRedactPatterns = map[string]string{
+		"email":         `[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`,
+		"card":          `\b(?:4[0-9]{3}|5[1-5][0-9]{2}|3[47][0-9]{2}|6(?:011|5[0-9]{2}))(?:[ -]?[0-9]{4}){2}[ -]?[0-9]{1,4}\b`,
+		"bearer":        `(?i)\bbearer\s+([A-Za-z0-9\-._~+/]+=*)`,
+		"authorization": `(?i)authorization\\?["']?\s*[:=]\s*\\?["']?([^"'\\\r\n,}]+)`,
+	}
+)
+
+// redactRule masks, hashes or drops sensitive data. Rules with a field act on that field, addressed by a dotted path,
+// and drop removes the field. Rules without a field act on every value of the event, and drop discards the whole event.
+type redactRule struct {
+	Name    string `json:"name"`
+	Pattern string `json:"pattern,omitempty"`
+	Field   string `json:"field,omitempty"`
+	Action  string `json:"action,omitempty"`
+	Mask    string `json:"mask,omitempty"`
+	regex   *regexp.Regexp
+	hits    metrics.Counter
+}
+
+type redactor struct {
+	rules []*redactRule
+	key   []byte
+}
+
+func loadRedactRules(path string) ([]redactRule, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	rules := []redactRule{}
+	if err := json.NewDecoder(f).Decode(&rules); err != nil {
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+	return rules, nil
+}
+
+// redactRulesFromFlags combines the builtin rules enabled by -redact, which mask, with the rules of -redactRules
+func redactRulesFromFlags() ([]redactRule, error) {
+	rules := []redactRule{}
+	for _, name := range splitList(redactBuiltins) {
+		rules = append(rules, redactRule{Name: name})
+	}
+	if redactRulesFile != "" {
+		fileRules, err := loadRedactRules(redactRulesFile)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, fileRules...)
+	}
+	return rules, nil
+}
+
+func newRedactor(rules []redactRule, key string) (*redactor, error) {
+	r := &redactor{key: []byte(key)}
+	for i, rule := range rules {
+		rule := rule
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule%v", i+1)
+		}
+		if rule.Pattern == "" && rule.Field == "" {
+			pattern, found := builtinRedactPatterns[rule.Name]
+			if !found {
+				return nil, fmt.Errorf("redact rule %v: pattern or field required", rule.Name)
+			}
+			rule.Pattern = pattern
+		}
+		if rule.Pattern != "" {
+			re, err := regexp.Compile(rule.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("redact rule %v: %v", rule.Name, err)
+			}
+			rule.regex = re
+		}
+		switch rule.Action {
+		case "":
+			rule.Action = redactMask
+		case redactMask, redactDrop:
+		case redactHash:
+			if key == "" {
+				return nil, fmt.Errorf("redact rule %v: hashing requires -redactKey", rule.Name)
+			}
+		default:
+			return nil, fmt.Errorf("redact rule %v: unknown action %q, expected mask, hash or drop", rule.Name, rule.Action)
+		}
+		if rule.Mask == "" {
+			rule.Mask = defaultMask
+		}
+		rule.hits = metrics.GetOrRegisterCounter("redact."+rule.Name+".hits", metrics.DefaultRegistry)
+		r.rules = append(r.rules, &rule)
+	}
+	return r, nil
+}
+
+func (r *redactor) process(e *logEvent, emit func(*logEvent)) {
+	for _, rule := range r.rules {
+		var hit bool
+		switch {
+		case rule.Field != "":
+			hit = r.redactField(e, rule)
+		case e.Fields() != nil:
+			hit = r.redactValues(e.fields, rule)
+			if hit {
+				e.modified = true
+			}
+		default:
+			e.raw, hit = r.redactString(e.raw, rule)
+		}
+		if hit {
+			rule.hits.Inc(1)
+			if rule.Action == redactDrop && rule.Field == "" {
+				e.done()
+				return
+			}
+		}
+	}
+	emit(e)
+}
+
+func (r *redactor) redactField(e *logEvent, rule *redactRule) bool {
+	parent, key := lookupPath(e.Fields(), rule.Field)
+	if parent == nil {
+		return false
+	}
+	value := fieldString(parent[key])
+	if rule.regex != nil && !rule.regex.MatchString(value) {
+		return false
+	}
+
+	switch {
+	case rule.Action == redactDrop:
+		delete(parent, key)
+	case rule.regex != nil:
+		parent[key], _ = r.redactString(value, rule)
+	default:
+		parent[key] = r.redacted(value, rule)
+	}
+	e.modified = true
+	return true
+}
+
+// redactValues redacts every string found in a decoded json value
+func (r *redactor) redactValues(v interface{}, rule *redactRule) bool {
+	hit := false
+	switch val := v.(type) {
+	case map[string]interface{}:
+		for k, item := range val {
+			if s, ok := item.(string); ok {
+				var redacted bool
+				val[k], redacted = r.redactString(s, rule)
+				hit = hit || redacted
+			} else if r.redactValues(item, rule) {
+				hit = true
+			}
+		}
+	case []interface{}:
+		for i, item := range val {
+			if s, ok := item.(string); ok {
+				var redacted bool
+				val[i], redacted = r.redactString(s, rule)
+				hit = hit || redacted
+			} else if r.redactValues(item, rule) {
+				hit = true
+			}
+		}
+	}
+	return hit
+}
+
+func (r *redactor) redactString(s string, rule *redactRule) (string, bool) {
+	matches := rule.regex.FindAllStringSubmatchIndex(s, -1)
+	if len(matches) == 0 {
+		return s, false
+	}
+	out := &bytes.Buffer{}
+	last := 0
+	for _, m := range matches {
+		start, end := m[0], m[1]
+		if len(m) >= 4 && m[2] >= 0 {
+			start, end = m[2], m[3]
+		}
+		out.WriteString(s[last:start])
+		out.WriteString(r.redacted(s[start:end], rule))
+		last = end
+	}
+	out.WriteString(s[last:])
+	return out.String(), true
+}
+
+func (r *redactor) redacted(s string, rule *redactRule) string {
+	switch rule.Action {
+	case redactHash:
+		mac := hmac.New(sha256.New, r.key)
+		mac.Write([]byte(s))
+		return hex.EncodeToString(mac.Sum(nil))
+	case redactDrop:
+		return ""
+	}
+	return rule.Mask
+}
+
+// lookupPath finds the object holding the field addressed by a dotted path.
+// A key containing dots is matched as a whole before it is split.
+func lookupPath(fields map[string]interface{}, path string) (map[string]interface{}, string) {
+	if fields == nil {
+		return nil, ""
+	}
+	if _, found := fields[path]; found {
+		return fields, path
+	}
+	parts := strings.SplitN(path, ".", 2)
+	if len(parts) < 2 {
+		return nil, ""
+	}
+	child, ok := fields[parts[0]].(map[string]interface{})
+	if !ok {
+		return nil, ""
+	}
+	return lookupPath(child, parts[1])
+}
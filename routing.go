@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+)
+
+var (
+	routesFile  string
+	routeSample string
+	eventRouter *router
+)
+
+// routeRule sets the HEC index, sourcetype, source and host of the events matching all of its conditions.
+// A rule without conditions matches every event.
+type routeRule struct {
+	Name       string           `json:"name"`
+	Conditions []routeCondition `json:"conditions"`
+	Index      string           `json:"index,omitempty"`
+	Sourcetype string           `json:"sourcetype,omitempty"`
+	Source     string           `json:"source,omitempty"`
+	Host       string           `json:"host,omitempty"`
+}
+
+// routeCondition matches the value of an event field exactly, by prefix, regex or glob pattern
+type routeCondition struct {
+	Field string `json:"field"`
+	Match string `json:"match"`
+	Value string `json:"value"`
+	regex *regexp.Regexp
+}
+
+type router struct {
+	rules []routeRule
+}
+
+func loadRoutes(path string) ([]routeRule, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	rules := []routeRule{}
+	if err := json.NewDecoder(f).Decode(&rules); err != nil {
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+	return rules, nil
+}
+
+func newRouter(rules []routeRule) (*router, error) {
+	for i := range rules {
+		if rules[i].Name == "" {
+			rules[i].Name = fmt.Sprintf("#%v", i+1)
+		}
+		if err := compileConditions(rules[i].Conditions); err != nil {
+			return nil, fmt.Errorf("route %v: %v", rules[i].Name, err)
+		}
+	}
+	return &router{rules}, nil
+}
+
+func compileConditions(conditions []routeCondition) error {
+	for j := range conditions {
+		c := &conditions[j]
+		if c.Field == "" {
+			return fmt.Errorf("condition %v has no field", j+1)
+		}
+		switch c.Match {
+		case "", "exact", "prefix":
+		case "regex":
+			re, err := regexp.Compile(c.Value)
+			if err != nil {
+				return err
+			}
+			c.regex = re
+		case "glob":
+			if _, err := path.Match(c.Value, ""); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unknown match %q, expected exact, prefix, regex or glob", c.Match)
+		}
+	}
+	return nil
+}
+
+func matchesAll(conditions []routeCondition, e *logEvent) bool {
+	for j := range conditions {
+		if !conditions[j].matches(e) {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *routeCondition) matches(e *logEvent) bool {
+	value, found := e.Field(c.Field)
+	if !found {
+		return false
+	}
+	switch c.Match {
+	case "prefix":
+		return strings.HasPrefix(value, c.Value)
+	case "regex":
+		return c.regex.MatchString(value)
+	case "glob":
+		matched, _ := path.Match(c.Value, value)
+		return matched
+	default:
+		return value == c.Value
+	}
+}
+
+// match returns the first rule matching the event, or nil
+func (r *router) match(e *logEvent) *routeRule {
+	for i := range r.rules {
+		if matchesAll(r.rules[i].Conditions, e) {
+			return &r.rules[i]
+		}
+	}
+	return nil
+}
+
+// route applies the HEC metadata of the first matching rule to the event
+func (r *router) route(e *logEvent) *routeRule {
+	rule := r.match(e)
+	if rule == nil {
+		return nil
+	}
+	if rule.Index != "" {
+		e.index = rule.Index
+	}
+	if rule.Sourcetype != "" {
+		e.sourcetype = rule.Sourcetype
+	}
+	if rule.Source != "" {
+		e.source = rule.Source
+	}
+	if rule.Host != "" {
+		e.host = rule.Host
+	}
+	return rule
+}
+
+func setupRouter() error {
+	if routesFile == "" {
+		eventRouter = nil
+		return nil
+	}
+	rules, err := loadRoutes(routesFile)
+	if err != nil {
+		return err
+	}
+	eventRouter, err = newRouter(rules)
+	return err
+}
+
+// routeDryRun reports which rule routes the sample line and the resulting HEC metadata
+func routeDryRun(sample string, w io.Writer) error {
+	if err := setupRouter(); err != nil {
+		return err
+	}
+	if eventRouter == nil {
+		return fmt.Errorf("no routing rules given, use -routes")
+	}
+	e := newLogEvent(sample)
+	e.decorate()
+	rule := eventRouter.match(e)
+	if rule == nil {
+		fmt.Fprintln(w, "No route matched")
+		return nil
+	}
+	fmt.Fprintf(w, "Route %v matched: index=%q sourcetype=%q source=%q host=%q\n", rule.Name, e.index, e.sourcetype, e.source, e.host)
+	return nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testRouter(t *testing.T) *router {
+	rules, err := loadRoutes("testdata/routes.json")
+	assert.NoError(t, err)
+	r, err := newRouter(rules)
+	assert.NoError(t, err)
+	return r
+}
+
+func Test_Router_FirstMatchWins(t *testing.T) {
+	r := testRouter(t)
+
+	e := &logEvent{raw: `{"platform":"up-coco","SYSTEMD_UNIT":"annotations-mapper@2.service","service_name":"annotations-mapper"}`}
+	assert.Equal(t, "publishing", r.route(e).Name)
+	assert.Equal(t, "upp", e.index)
+	assert.Equal(t, "annotations", e.sourcetype)
+
+	e = &logEvent{raw: `{"platform":"up-neo4j","SYSTEMD_UNIT":"annotations-mapper@2.service","service_name":"annotations-mapper"}`, sourcetype: "journald"}
+	assert.Equal(t, "mappers", r.route(e).Name)
+	assert.Equal(t, "mappers", e.index)
+	assert.Equal(t, "journald", e.sourcetype)
+
+	e = &logEvent{raw: `{"SYSTEMD_UNIT":"kubelet.service"}`}
+	assert.Equal(t, "kubernetes", r.route(e).Name)
+	assert.Equal(t, "k8s", e.index)
+	assert.Equal(t, "kubernetes", e.source)
+	assert.Equal(t, "k8s-node", e.host)
+
+	e = &logEvent{raw: `not json`}
+	assert.Equal(t, "default", r.route(e).Name)
+	assert.Equal(t, "default", e.index)
+}
+
+func Test_Router_NoMatch(t *testing.T) {
+	r, err := newRouter([]routeRule{{Conditions: []routeCondition{{Field: "level", Value: "error"}}, Index: "errors"}})
+	assert.NoError(t, err)
+
+	e := &logEvent{raw: `{"level":"info"}`}
+	assert.Nil(t, r.route(e))
+	assert.Empty(t, e.index)
+}
+
+func Test_NewRouter_Invalid(t *testing.T) {
+	for _, c := range []routeCondition{
+		{Value: "x"},
+		{Field: "level", Match: "regex", Value: "("},
+		{Field: "level", Match: "glob", Value: "["},
+		{Field: "level", Match: "contains", Value: "x"},
+	} {
+		_, err := newRouter([]routeRule{{Conditions: []routeCondition{c}}})
+		assert.Error(t, err, "%+v", c)
+	}
+}
+
+func Test_RouteDryRun(t *testing.T) {
+	defer func(file string) { routesFile, eventRouter = file, nil }(routesFile)
+	routesFile = "testdata/routes.json"
+
+	out := &bytes.Buffer{}
+	assert.NoError(t, routeDryRun(`{"SYSTEMD_UNIT":"kubelet.service"}`, out))
+	assert.Equal(t, "Route kubernetes matched: index=\"k8s\" sourcetype=\"\" source=\"kubernetes\" host=\"k8s-node\"\n", out.String())
+
+	routesFile = ""
+	assert.Error(t, routeDryRun(`{"SYSTEMD_UNIT":"kubelet.service"}`, out))
+}
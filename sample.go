@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/rand"
+	"os"
+	"strconv"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+const sampleRateField = "sample_rate"
+
+var sampleRulesFile string
+
+// sampleRule keeps a fraction of the events matching all its conditions. The rate is looked up in rates by the value of
+// field, falling back to rate (1 when omitted). Events sharing the value of the key field, e.g. a transaction_id, are
+// kept or dropped together; events without it are sampled at random.
+type sampleRule struct {
+	Name       string             `json:"name"`
+	Conditions []routeCondition   `json:"conditions,omitempty"`
+	Field      string             `json:"field,omitempty"`
+	Rates      map[string]float64 `json:"rates,omitempty"`
+	Rate       *float64           `json:"rate,omitempty"`
+	Key        string             `json:"key,omitempty"`
+	dropped    metrics.Counter
+}
+
+type sampler struct {
+	rules []*sampleRule
+}
+
+func loadSampleRules(path string) ([]sampleRule, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	rules := []sampleRule{}
+	if err := json.NewDecoder(f).Decode(&rules); err != nil {
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+	return rules, nil
+}
+
+func newSampler(rules []sampleRule) (*sampler, error) {
+	s := &sampler{}
+	for i, rule := range rules {
+		rule := rule
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule%v", i+1)
+		}
+		if err := compileConditions(rule.Conditions); err != nil {
+			return nil, fmt.Errorf("sample rule %v: %v", rule.Name, err)
+		}
+		if rule.Rate == nil {
+			one := 1.0
+			rule.Rate = &one
+		}
+		rates := []float64{*rule.Rate}
+		for _, rate := range rule.Rates {
+			rates = append(rates, rate)
+		}
+		for _, rate := range rates {
+			if rate < 0 || rate > 1 {
+				return nil, fmt.Errorf("sample rule %v: rate %v is not between 0 and 1", rule.Name, rate)
+			}
+		}
+		if len(rule.Rates) > 0 && rule.Field == "" {
+			return nil, fmt.Errorf("sample rule %v: rates require a field", rule.Name)
+		}
+		rule.dropped = metrics.GetOrRegisterCounter("sample."+rule.Name+".dropped", metrics.DefaultRegistry)
+		s.rules = append(s.rules, &rule)
+	}
+	return s, nil
+}
+
+func (s *sampler) process(e *logEvent, emit func(*logEvent)) {
+	for _, rule := range s.rules {
+		if !matchesAll(rule.Conditions, e) {
+			continue
+		}
+		rate := rule.rate(e)
+		if rate >= 1 {
+			break
+		}
+		if sampleValue(e, rule.Key) >= rate {
+			rule.dropped.Inc(1)
+			e.done()
+			return
+		}
+		if e.indexed == nil {
+			e.indexed = map[string]interface{}{}
+		}
+		e.indexed[sampleRateField] = strconv.FormatFloat(rate, 'g', -1, 64)
+		break
+	}
+	emit(e)
+}
+
+func (rule *sampleRule) rate(e *logEvent) float64 {
+	if rule.Field != "" {
+		if value, found := e.Field(rule.Field); found {
+			if rate, found := rule.Rates[value]; found {
+				return rate
+			}
+		}
+	}
+	return *rule.Rate
+}
+
+// sampleValue maps the key field of the event onto [0, 1), consistently for equal values
+func sampleValue(e *logEvent, key string) float64 {
+	if key != "" {
+		if value, found := e.Field(key); found && value != "" {
+			h := fnv.New64a()
+			h.Write([]byte(value))
+			// fnv leaves the high bits of short values poorly mixed, finalise as murmur3 does
+			x := h.Sum64()
+			x ^= x >> 33
+			x *= 0xff51afd7ed558ccd
+			x ^= x >> 33
+			x *= 0xc4ceb9fe1a85ec53
+			x ^= x >> 33
+			return float64(x>>11) / float64(math.MaxUint64>>11+1)
+		}
+	}
+	return rand.Float64()
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/stretchr/testify/assert"
+)
+
+func testSampler(t *testing.T) *sampler {
+	rules, err := loadSampleRules("testdata/samples.json")
+	assert.NoError(t, err)
+	s, err := newSampler(rules)
+	assert.NoError(t, err)
+	return s
+}
+
+func sampleAll(s *sampler, lines []string) []*logEvent {
+	kept := []*logEvent{}
+	for _, line := range lines {
+		s.process(newLogEvent(line), func(e *logEvent) { kept = append(kept, e) })
+	}
+	return kept
+}
+
+func Test_Sampler_Rates(t *testing.T) {
+	s := testSampler(t)
+	dropped := metrics.GetOrRegisterCounter("sample.services.dropped", metrics.DefaultRegistry).Count()
+
+	lines := []string{}
+	for i := 0; i < 2000; i++ {
+		lines = append(lines, fmt.Sprintf(`{"service_name":"annotations-mapper","level":"info","transaction_id":"tid_%v"}`, i))
+	}
+	kept := sampleAll(s, lines)
+	assert.InDelta(t, 200, len(kept), 60)
+	assert.Equal(t, dropped+int64(len(lines)-len(kept)), metrics.GetOrRegisterCounter("sample.services.dropped", metrics.DefaultRegistry).Count())
+	for _, e := range kept {
+		assert.Equal(t, "0.1", e.indexed[sampleRateField])
+	}
+
+	assert.Empty(t, sampleAll(s, []string{`{"service_name":"content-ingester","transaction_id":"tid_1"}`}))
+
+	kept = sampleAll(s, []string{`{"service_name":"content-ingester","level":"error","transaction_id":"tid_1"}`})
+	assert.Len(t, kept, 1)
+	assert.Nil(t, kept[0].indexed)
+}
+
+func Test_Sampler_KeepsTransactionsTogether(t *testing.T) {
+	s := testSampler(t)
+
+	for i := 0; i < 50; i++ {
+		lines := []string{
+			fmt.Sprintf(`{"service_name":"annotations-mapper","transaction_id":"tid_%v","msg":"start"}`, i),
+			fmt.Sprintf(`{"service_name":"annotations-mapper","transaction_id":"tid_%v","msg":"mapped"}`, i),
+			fmt.Sprintf(`{"service_name":"other-service","transaction_id":"tid_%v","msg":"received"}`, i),
+		}
+		kept := sampleAll(s, lines)
+		switch len(kept) {
+		case 0:
+		case 1:
+			assert.Contains(t, kept[0].raw, "other-service")
+		case 3:
+		default:
+			t.Errorf("transaction tid_%v was split: %v of %v lines kept", i, len(kept), len(lines))
+		}
+	}
+}
+
+func Test_NewSampler_Invalid(t *testing.T) {
+	tooHigh := 1.5
+	for _, rule := range []sampleRule{
+		{Rate: &tooHigh},
+		{Field: "level", Rates: map[string]float64{"debug": -0.1}},
+		{Rates: map[string]float64{"debug": 0.1}},
+		{Conditions: []routeCondition{{Value: "x"}}},
+	} {
+		_, err := newSampler([]sampleRule{rule})
+		assert.Error(t, err, "%+v", rule)
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+var (
+	socketPath string
+	socketType string
+	socketMode string
+	fifoPath   string
+)
+
+// inputMetrics counts the events of an input and how often it had to wait for the pipeline to take them, i.e. how
+// often it applied backpressure to its writers
+type inputMetrics struct {
+	received metrics.Counter
+	blocked  metrics.Counter
+}
+
+func newInputMetrics(name string) inputMetrics {
+	return inputMetrics{
+		received: metrics.GetOrRegisterCounter(name+".received", metrics.DefaultRegistry),
+		blocked:  metrics.GetOrRegisterCounter(name+".blocked", metrics.DefaultRegistry),
+	}
+}
+
+// send passes an event to the pipeline, waiting for it when it is busy. While waiting the input stops reading, so that
+// writers of stream sockets and FIFOs block once the kernel buffers are full.
+func (m inputMetrics) send(events chan<- *logEvent, e *logEvent) {
+	m.received.Inc(1)
+	select {
+	case events <- e:
+	default:
+		m.blocked.Inc(1)
+		events <- e
+	}
+}
+
+// sendFrames sends the messages read from r until it is exhausted
+func (m inputMetrics) sendFrames(events chan<- *logEvent, r io.Reader) error {
+	return readFrames(r, func(e *logEvent) { m.send(events, e) })
+}
+
+// socketInput reads the messages framed by -framing from the connections of a Unix stream socket, or one message per
+// datagram from a Unix datagram socket, truncated to -maxMessageBytes
+type socketInput struct {
+	stream   net.Listener
+	datagram *net.UnixConn
+	metrics  inputMetrics
+}
+
+func newSocketInput(path, socketType, mode string) (*socketInput, error) {
+	if path == "" {
+		return nil, fmt.Errorf("socket input requires -socketPath")
+	}
+	perm, err := parseFileMode(mode)
+	if err != nil {
+		return nil, err
+	}
+	if err := removeStaleSocket(path); err != nil {
+		return nil, err
+	}
+	s := &socketInput{metrics: newInputMetrics("socket_input")}
+	switch socketType {
+	case "stream":
+		s.stream, err = net.Listen("unix", path)
+	case "datagram":
+		s.datagram, err = net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	default:
+		return nil, fmt.Errorf("unknown socket type %q, expected stream or datagram", socketType)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, perm); err != nil {
+		s.close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *socketInput) run(events chan<- *logEvent) error {
+	if s.datagram != nil {
+		return s.serveDatagrams(events)
+	}
+	for {
+		conn, err := s.stream.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				continue
+			}
+			return err
+		}
+		go func() {
+			defer conn.Close()
+			if err := s.metrics.sendFrames(events, conn); err != nil {
+				log.Printf("Failed to read from socket connection: %v\n", err)
+			}
+		}()
+	}
+}
+
+func (s *socketInput) serveDatagrams(events chan<- *logEvent) error {
+	buf := make([]byte, maxMessageBytes)
+	for {
+		// the kernel drops the rest of a datagram longer than the buffer, and tells so by MSG_TRUNC
+		n, _, flags, _, err := s.datagram.ReadMsgUnix(buf, nil)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				continue
+			}
+			return err
+		}
+		if msg := strings.TrimRight(string(buf[:n]), "\n\x00"); msg != "" {
+			e := newLogEvent(msg)
+			if flags&syscall.MSG_TRUNC != 0 {
+				markTruncated(e)
+			}
+			s.metrics.send(events, e)
+		}
+	}
+}
+
+func (s *socketInput) close() {
+	if s.stream != nil {
+		s.stream.Close()
+	}
+	if s.datagram != nil {
+		s.datagram.Close()
+		// unlike stream listeners, datagram sockets leave their file behind
+		os.Remove(s.datagram.LocalAddr().String())
+	}
+}
+
+// fifoInput reads the messages framed by -framing from a named pipe, created if it does not exist. The pipe is opened
+// again whenever its writers are gone, even in the middle of a message, so sidecars can restart without stopping the
+// forwarder.
+type fifoInput struct {
+	path    string
+	metrics inputMetrics
+}
+
+func newFIFOInput(path string) (*fifoInput, error) {
+	if path == "" {
+		return nil, fmt.Errorf("fifo input requires -fifoPath")
+	}
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		if err := syscall.Mkfifo(path, 0660); err != nil {
+			return nil, fmt.Errorf("creating fifo %v: %v", path, err)
+		}
+	} else if err != nil {
+		return nil, err
+	} else if info.Mode()&os.ModeNamedPipe == 0 {
+		return nil, fmt.Errorf("%v is not a fifo", path)
+	}
+	return &fifoInput{path: path, metrics: newInputMetrics("fifo_input")}, nil
+}
+
+func (in *fifoInput) run(events chan<- *logEvent) error {
+	for {
+		// opening blocks until a writer opens the pipe, and reading ends once all writers closed it
+		f, err := os.Open(in.path)
+		if err != nil {
+			return err
+		}
+		if err := in.metrics.sendFrames(events, f); err != nil {
+			log.Printf("Failed to read from fifo %v: %v\n", in.path, err)
+		}
+		f.Close()
+	}
+}
+
+// removeStaleSocket removes the socket file left behind by a previous run, but nothing else
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%v exists and is not a socket", path)
+	}
+	return os.Remove(path)
+}
+
+// parseFileMode reads octal permissions, e.g. 0660
+func parseFileMode(s string) (os.FileMode, error) {
+	mode, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, fmt.Errorf("invalid permissions %q, expected octal such as 0660", s)
+	}
+	return os.FileMode(mode), nil
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_SocketInput_Stream(t *testing.T) {
+	dir, err := ioutil.TempDir("", "socket")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "forwarder.sock")
+	s, err := newSocketInput(path, "stream", "0600")
+	assert.NoError(t, err)
+	defer s.close()
+	info, err := os.Stat(path)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	events := make(chan *logEvent)
+	go s.run(events)
+	conn, err := net.Dial("unix", path)
+	assert.NoError(t, err)
+	conn.Write([]byte("first\nsecond\n"))
+	conn.Close()
+	assert.Equal(t, "first\n", receive(t, events).raw)
+	assert.Equal(t, "second\n", receive(t, events).raw)
+	assert.True(t, s.metrics.received.Count() >= 2)
+}
+
+func Test_SocketInput_Datagram(t *testing.T) {
+	dir, err := ioutil.TempDir("", "socket")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "forwarder.sock")
+	// a socket left behind by a previous run is replaced
+	stale, err := net.ListenPacket("unixgram", path)
+	assert.NoError(t, err)
+	stale.Close()
+
+	s, err := newSocketInput(path, "datagram", "0660")
+	assert.NoError(t, err)
+	defer s.close()
+	events := make(chan *logEvent)
+	go s.run(events)
+	conn, err := net.Dial("unixgram", path)
+	assert.NoError(t, err)
+	defer conn.Close()
+	conn.Write([]byte("one message\nover two lines\n"))
+	assert.Equal(t, "one message\nover two lines", receive(t, events).raw)
+}
+
+func Test_SocketInput_TruncatesDatagrams(t *testing.T) {
+	defer withFraming(framingNewline, 8)()
+	dir, err := ioutil.TempDir("", "socket")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "forwarder.sock")
+	s, err := newSocketInput(path, "datagram", "0660")
+	assert.NoError(t, err)
+	defer s.close()
+	events := make(chan *logEvent)
+	go s.run(events)
+	conn, err := net.Dial("unixgram", path)
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	conn.Write([]byte("a message too long"))
+	e := receive(t, events)
+	assert.Equal(t, "a messag", e.raw)
+	assert.Equal(t, "true", e.indexed[truncatedField])
+	conn.Write([]byte("short"))
+	e = receive(t, events)
+	assert.Equal(t, "short", e.raw)
+	assert.Nil(t, e.indexed)
+}
+
+func Test_NewSocketInput_Invalid(t *testing.T) {
+	dir, err := ioutil.TempDir("", "socket")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "forwarder.sock")
+	_, err = newSocketInput(path, "seqpacket", "0660")
+	assert.Error(t, err)
+	_, err = newSocketInput(path, "stream", "rw-rw----")
+	assert.Error(t, err)
+
+	// regular files are not removed
+	assert.NoError(t, ioutil.WriteFile(path, []byte("data"), 0644))
+	_, err = newSocketInput(path, "stream", "0660")
+	assert.Error(t, err)
+	_, err = os.Stat(path)
+	assert.NoError(t, err)
+}
+
+func Test_FIFOInput_ReopensAfterWritersDisconnect(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fifo")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "forwarder.fifo")
+	in, err := newFIFOInput(path)
+	assert.NoError(t, err)
+	info, err := os.Stat(path)
+	assert.NoError(t, err)
+	assert.NotEqual(t, os.FileMode(0), info.Mode()&os.ModeNamedPipe)
+
+	events := make(chan *logEvent)
+	go in.run(events)
+	for _, line := range []string{"first writer\n", "second writer\n"} {
+		w, err := os.OpenFile(path, os.O_WRONLY, 0)
+		assert.NoError(t, err)
+		w.WriteString(line)
+		w.Close()
+		assert.Equal(t, line, receive(t, events).raw)
+	}
+
+	_, err = newFIFOInput(filepath.Join(dir, "missing", "forwarder.fifo"))
+	assert.Error(t, err)
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "file"), nil, 0644))
+	_, err = newFIFOInput(filepath.Join(dir, "file"))
+	assert.Error(t, err)
+}
+
+func Test_FIFOInput_ReopensAfterIncompleteMessage(t *testing.T) {
+	defer withFraming(framingLength, 1024)()
+	dir, err := ioutil.TempDir("", "fifo")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "forwarder.fifo")
+	in, err := newFIFOInput(path)
+	assert.NoError(t, err)
+
+	events := make(chan *logEvent)
+	go in.run(events)
+	w, err := os.OpenFile(path, os.O_WRONLY, 0)
+	assert.NoError(t, err)
+	w.Write([]byte{0, 0, 0, 10, 'c', 'u', 't'}) // the writer goes away in the middle of a message
+	w.Close()
+	time.Sleep(200 * time.Millisecond) // writers opening the pipe before the reader sees the end of it continue the stream
+
+	w, err = os.OpenFile(path, os.O_WRONLY, 0)
+	assert.NoError(t, err)
+	w.Write([]byte{0, 0, 0, 6, 's', 'e', 'c', 'o', 'n', 'd'})
+	w.Close()
+	assert.Equal(t, "second", receive(t, events).raw)
+}
+
+func Test_InputMetrics_CountsBackpressure(t *testing.T) {
+	m := newInputMetrics("test_input")
+	events := make(chan *logEvent, 1)
+	m.send(events, newLogEvent("fits"))
+	assert.Equal(t, int64(0), m.blocked.Count())
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		<-events
+		<-events
+	}()
+	m.send(events, newLogEvent("waits"))
+	assert.Equal(t, int64(2), m.received.Count())
+	assert.Equal(t, int64(1), m.blocked.Count())
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// stage processes events between reading and batching. An event is passed on by calling emit, dropped by not calling it.
+type stage interface {
+	process(e *logEvent, emit func(*logEvent))
+}
+
+// ticker is implemented by stages with time based behaviour. tick is called as events arrive and when the batch timer expires.
+type ticker interface {
+	tick(now time.Time, emit func(*logEvent))
+}
+
+// flusher is implemented by stages holding events back. flush releases them all before exit.
+type flusher interface {
+	flush(emit func(*logEvent))
+}
+
+// pipeline chains stages, each emitting into the next one
+type pipeline []stage
+
+func (p pipeline) process(e *logEvent, emit func(*logEvent)) {
+	if len(p) == 0 {
+		emit(e)
+		return
+	}
+	p[0].process(e, func(e *logEvent) {
+		p[1:].process(e, emit)
+	})
+}
+
+// tick lets the stages emit time based events, passing them through the rest of the pipeline
+func (p pipeline) tick(now time.Time, emit func(*logEvent)) {
+	for i, s := range p {
+		if t, ok := s.(ticker); ok {
+			rest := p[i+1:]
+			t.tick(now, func(e *logEvent) {
+				rest.process(e, emit)
+			})
+		}
+	}
+}
+
+// flush releases the events held back by the stages, passing them through the rest of the pipeline
+func (p pipeline) flush(emit func(*logEvent)) {
+	for i, s := range p {
+		if f, ok := s.(flusher); ok {
+			rest := p[i+1:]
+			f.flush(func(e *logEvent) {
+				rest.process(e, emit)
+			})
+		}
+	}
+}
+
+// newStages builds the processing stages configured by the flags
+func newStages() (pipeline, error) {
+	p := pipeline{}
+	if dedup {
+		path := ""
+		if stateDir != "" {
+			if err := os.MkdirAll(stateDir, 0755); err != nil {
+				return nil, err
+			}
+			path = filepath.Join(stateDir, dedupStateFile)
+		}
+		d, err := newDeduplicator(dedupField, time.Duration(dedupWindow)*time.Second, dedupSize, path)
+		if err != nil {
+			return nil, err
+		}
+		p = append(p, d)
+	}
+	if multilineStart != "" || multilineContinue != "" {
+		m, err := newMultiline(multilineStart, multilineContinue, multilineField, multilineKey, multilineMaxLines, multilineMaxBytes, time.Duration(multilineTimeoutMs)*time.Millisecond)
+		if err != nil {
+			return nil, err
+		}
+		p = append(p, m)
+	}
+	enrichment, err := enrichmentFromFlags()
+	if err != nil {
+		return nil, err
+	}
+	if enrichment != nil {
+		p = append(p, enrichment)
+	}
+	if filterRulesFile != "" {
+		rules, err := loadFilterRules(filterRulesFile)
+		if err != nil {
+			return nil, err
+		}
+		f, err := newFilter(rules, filterShadow)
+		if err != nil {
+			return nil, err
+		}
+		p = append(p, f)
+	}
+	if sampleRulesFile != "" {
+		rules, err := loadSampleRules(sampleRulesFile)
+		if err != nil {
+			return nil, err
+		}
+		s, err := newSampler(rules)
+		if err != nil {
+			return nil, err
+		}
+		p = append(p, s)
+	}
+	if rateLimit > 0 {
+		r, err := newRateLimiter(rateLimitField, rateLimit, rateLimitBurst, rateLimitAction, rateLimitSampleRate, time.Duration(rateLimitSummary)*time.Second)
+		if err != nil {
+			return nil, err
+		}
+		p = append(p, r)
+	}
+	rules, err := redactRulesFromFlags()
+	if err != nil {
+		return nil, err
+	}
+	if len(rules) > 0 {
+		r, err := newRedactor(rules, redactKey)
+		if err != nil {
+			return nil, err
+		}
+		p = append(p, r)
+	}
+	return p, nil
+}
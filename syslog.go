@@ -0,0 +1,350 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+// syslogMaxMessage bounds the size of a datagram
+const syslogMaxMessage = 64 * 1024
+
+var (
+	syslogUDP        string
+	syslogTCP        string
+	syslogSourcetype string
+
+	syslogFacilities = []string{"kern", "user", "mail", "daemon", "auth", "syslog", "lpr", "news", "uucp", "cron", "authpriv",
+		"ftp", "ntp", "security", "console", "solaris-cron", "local0", "local1", "local2", "local3", "local4", "local5",
+		"local6", "local7"}
+	syslogSeverities = []string{"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug"}
+)
+
+// syslogMessage is the header and message of an RFC3164 or RFC5424 syslog message
+type syslogMessage struct {
+	facility       int
+	severity       int
+	timestamp      time.Time
+	hostname       string
+	appName        string
+	procID         string
+	msgID          string
+	structuredData string
+	message        string
+}
+
+// syslogInput receives syslog messages over UDP, one per datagram, and over TCP framed by octet counting or newlines
+type syslogInput struct {
+	udp      net.PacketConn
+	tcp      net.Listener
+	location *time.Location
+	received metrics.Counter
+	invalid  metrics.Counter
+}
+
+func newSyslogInput(udpAddr, tcpAddr string) (*syslogInput, error) {
+	if udpAddr == "" && tcpAddr == "" {
+		return nil, fmt.Errorf("syslog input requires -syslogUDP or -syslogTCP")
+	}
+	location, err := time.LoadLocation(timezone)
+	if err != nil {
+		return nil, err
+	}
+	s := &syslogInput{
+		location: location,
+		received: metrics.GetOrRegisterCounter("syslog.received", metrics.DefaultRegistry),
+		invalid:  metrics.GetOrRegisterCounter("syslog.invalid", metrics.DefaultRegistry),
+	}
+	if udpAddr != "" {
+		if s.udp, err = net.ListenPacket("udp", udpAddr); err != nil {
+			return nil, err
+		}
+	}
+	if tcpAddr != "" {
+		if s.tcp, err = net.Listen("tcp", tcpAddr); err != nil {
+			if s.udp != nil {
+				s.udp.Close()
+			}
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func (s *syslogInput) run(events chan<- *logEvent) error {
+	errs := make(chan error, 2)
+	listeners := 0
+	if s.udp != nil {
+		listeners++
+		go func() { errs <- s.serveUDP(events) }()
+	}
+	if s.tcp != nil {
+		listeners++
+		go func() { errs <- s.serveTCP(events) }()
+	}
+	var err error
+	for i := 0; i < listeners; i++ {
+		if e := <-errs; e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+func (s *syslogInput) close() {
+	if s.udp != nil {
+		s.udp.Close()
+	}
+	if s.tcp != nil {
+		s.tcp.Close()
+	}
+}
+
+func (s *syslogInput) serveUDP(events chan<- *logEvent) error {
+	buf := make([]byte, syslogMaxMessage)
+	for {
+		n, _, err := s.udp.ReadFrom(buf)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				continue
+			}
+			return err
+		}
+		if msg := strings.TrimRight(string(buf[:n]), "\r\n\x00"); msg != "" {
+			events <- s.event(msg)
+		}
+	}
+}
+
+func (s *syslogInput) serveTCP(events chan<- *logEvent) error {
+	for {
+		conn, err := s.tcp.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				continue
+			}
+			return err
+		}
+		go func() {
+			defer conn.Close()
+			r := bufio.NewReader(conn)
+			for {
+				msg, truncated, err := readSyslogFrame(r)
+				if msg != "" {
+					e := s.event(msg)
+					if truncated {
+						markTruncated(e)
+					}
+					events <- e
+				}
+				if err != nil {
+					return
+				}
+			}
+		}()
+	}
+}
+
+func (s *syslogInput) event(msg string) *logEvent {
+	s.received.Inc(1)
+	e, ok := newSyslogEvent(msg, s.location, time.Now())
+	if !ok {
+		s.invalid.Inc(1)
+	}
+	return e
+}
+
+// readSyslogFrame reads a message framed by octet counting, "LEN SP MSG", or terminated by a newline (RFC6587). Messages
+// longer than -maxMessageBytes are truncated, which it tells, and the rest of their frame is discarded.
+func readSyslogFrame(r *bufio.Reader) (string, bool, error) {
+	b, err := r.Peek(1)
+	if err != nil {
+		return "", false, err
+	}
+	if b[0] < '1' || b[0] > '9' {
+		line, truncated, err := (&delimitedFramer{r, '\n', maxMessageBytes, false}).next()
+		return strings.TrimRight(line, "\r"), truncated, err
+	}
+	length := 0
+	for {
+		c, err := r.ReadByte()
+		if err != nil {
+			return "", false, err
+		}
+		if c == ' ' {
+			break
+		}
+		if c < '0' || c > '9' {
+			return "", false, fmt.Errorf("invalid syslog frame length")
+		}
+		if length = length*10 + int(c-'0'); length > math.MaxInt32 {
+			return "", false, fmt.Errorf("invalid syslog frame length")
+		}
+	}
+	size, truncated := length, false
+	if size > maxMessageBytes {
+		size, truncated = maxMessageBytes, true
+	}
+	buf := make([]byte, size)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return "", false, err
+	}
+	if _, err := r.Discard(length - size); err != nil {
+		return "", false, unexpectedEOF(err)
+	}
+	return strings.TrimRight(string(buf), "\r\n"), truncated, nil
+}
+
+// newSyslogEvent maps the header of a syslog message onto HEC metadata. The event body stays the original message.
+// Messages which can not be parsed are forwarded as they are.
+func newSyslogEvent(msg string, location *time.Location, now time.Time) (*logEvent, bool) {
+	e := &logEvent{raw: msg, precision: time.Millisecond, sourcetype: syslogSourcetype, time: now}
+	m, err := parseSyslog(msg, location, now)
+	if err != nil {
+		return e, false
+	}
+	facility, severity := syslogFacilities[m.facility], syslogSeverities[m.severity]
+	fields := map[string]interface{}{"facility": facility, "severity": severity, "message": m.message}
+	for k, v := range map[string]string{"hostname": m.hostname, "app_name": m.appName, "procid": m.procID,
+		"msgid": m.msgID, "structured_data": m.structuredData} {
+		if v != "" {
+			fields[k] = v
+		}
+	}
+	e.fields, e.parsed = fields, true
+	e.indexed = map[string]interface{}{"facility": facility, "severity": severity}
+	e.host, e.source = m.hostname, m.appName
+	if !m.timestamp.IsZero() {
+		e.time = m.timestamp
+		if m.timestamp.Nanosecond()%int(time.Millisecond) != 0 {
+			e.precision = time.Microsecond
+		}
+	}
+	return e, true
+}
+
+// parseSyslog parses an RFC5424 message, or an RFC3164 one whose timestamp lacks the year, taken from now
+func parseSyslog(msg string, location *time.Location, now time.Time) (*syslogMessage, error) {
+	if !strings.HasPrefix(msg, "<") {
+		return nil, fmt.Errorf("missing syslog priority")
+	}
+	end := strings.IndexByte(msg, '>')
+	if end < 2 || end > 4 {
+		return nil, fmt.Errorf("invalid syslog priority")
+	}
+	pri, err := strconv.Atoi(msg[1:end])
+	if err != nil || pri < 0 || pri >= len(syslogFacilities)*8 {
+		return nil, fmt.Errorf("invalid syslog priority %q", msg[1:end])
+	}
+	m := &syslogMessage{facility: pri / 8, severity: pri % 8}
+	rest := msg[end+1:]
+	if strings.HasPrefix(rest, "1 ") {
+		return m, parseRFC5424(m, rest[2:])
+	}
+	parseRFC3164(m, rest, location, now)
+	return m, nil
+}
+
+func parseRFC5424(m *syslogMessage, s string) error {
+	header := strings.SplitN(s, " ", 6)
+	if len(header) < 6 {
+		return fmt.Errorf("truncated RFC5424 header")
+	}
+	if header[0] != "-" {
+		t, err := time.Parse(time.RFC3339Nano, header[0])
+		if err != nil {
+			return err
+		}
+		m.timestamp = t
+	}
+	m.hostname, m.appName, m.procID, m.msgID = nilValue(header[1]), nilValue(header[2]), nilValue(header[3]), nilValue(header[4])
+
+	rest := header[5]
+	if strings.HasPrefix(rest, "-") {
+		rest = rest[1:]
+	} else {
+		end := structuredDataEnd(rest)
+		if end < 0 {
+			return fmt.Errorf("invalid structured data")
+		}
+		m.structuredData, rest = rest[:end], rest[end:]
+	}
+	m.message = strings.TrimPrefix(strings.TrimPrefix(rest, " "), "\xEF\xBB\xBF")
+	return nil
+}
+
+// structuredDataEnd returns the length of the structured data elements at the start of s, or -1 if they are malformed
+func structuredDataEnd(s string) int {
+	i := 0
+	for i < len(s) && s[i] == '[' {
+		quoted := false
+		for i++; i < len(s); i++ {
+			if s[i] == '\\' && quoted {
+				i++
+			} else if s[i] == '"' {
+				quoted = !quoted
+			} else if s[i] == ']' && !quoted {
+				break
+			}
+		}
+		if i >= len(s) {
+			return -1
+		}
+		i++
+	}
+	if i == 0 {
+		return -1
+	}
+	return i
+}
+
+func nilValue(s string) string {
+	if s == "-" {
+		return ""
+	}
+	return s
+}
+
+// parseRFC3164 parses the loosely specified BSD syslog format: "Mmm dd hh:mm:ss HOSTNAME TAG[PID]: MSG", where the
+// hostname and tag may be missing. Without a timestamp the whole remainder is the message.
+func parseRFC3164(m *syslogMessage, s string, location *time.Location, now time.Time) {
+	if len(s) < len(time.Stamp) {
+		m.message = s
+		return
+	}
+	t, err := time.ParseInLocation(time.Stamp, s[:len(time.Stamp)], location)
+	if err != nil {
+		m.message = s
+		return
+	}
+	now = now.In(location)
+	m.timestamp = t.AddDate(now.Year(), 0, 0)
+	if m.timestamp.After(now.Add(24 * time.Hour)) { // sent last year, e.g. on new year's eve
+		m.timestamp = m.timestamp.AddDate(-1, 0, 0)
+	}
+	rest := strings.TrimPrefix(s[len(time.Stamp):], " ")
+
+	if sp := strings.IndexByte(rest, ' '); sp > 0 && !isSyslogTag(rest[:sp]) {
+		m.hostname, rest = rest[:sp], rest[sp+1:]
+	}
+	if sp := strings.IndexByte(rest, ' '); sp > 0 && isSyslogTag(rest[:sp]) {
+		tag := strings.TrimSuffix(rest[:sp], ":")
+		if open := strings.IndexByte(tag, '['); open > 0 && strings.HasSuffix(tag, "]") {
+			m.procID, tag = tag[open+1:len(tag)-1], tag[:open]
+		}
+		m.appName, rest = tag, rest[sp+1:]
+	}
+	m.message = rest
+}
+
+// isSyslogTag tells whether a word is a tag like "sshd[1234]:" or "kernel:"
+func isSyslogTag(word string) bool {
+	return strings.HasSuffix(word, ":") || strings.HasSuffix(word, "]")
+}
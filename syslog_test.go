@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var syslogNow = time.Date(2017, time.August, 18, 14, 37, 15, 0, time.UTC)
+
+func Test_ParseSyslog_RFC5424(t *testing.T) {
+	m, err := parseSyslog(`<165>1 2017-08-18T14:37:15.123456Z router1.ft.com bgpd 1234 ID47 [exampleSDID@32473 iut="3" eventSource="App\]lication"][meta seq="1"] `+"\xEF\xBB\xBF"+`BGP peer down`, time.UTC, syslogNow)
+	assert.NoError(t, err)
+	assert.Equal(t, &syslogMessage{
+		facility:       20,
+		severity:       5,
+		timestamp:      time.Date(2017, time.August, 18, 14, 37, 15, 123456000, time.UTC),
+		hostname:       "router1.ft.com",
+		appName:        "bgpd",
+		procID:         "1234",
+		msgID:          "ID47",
+		structuredData: `[exampleSDID@32473 iut="3" eventSource="App\]lication"][meta seq="1"]`,
+		message:        "BGP peer down",
+	}, m)
+
+	m, err = parseSyslog("<13>1 - - - - - -", time.UTC, syslogNow)
+	assert.NoError(t, err)
+	assert.Equal(t, &syslogMessage{facility: 1, severity: 5}, m)
+}
+
+func Test_ParseSyslog_RFC3164(t *testing.T) {
+	m, err := parseSyslog("<34>Oct 11 22:14:15 mymachine su[230]: 'su root' failed for lonvick on /dev/pts/8", time.UTC, syslogNow)
+	assert.NoError(t, err)
+	assert.Equal(t, &syslogMessage{
+		facility:  4,
+		severity:  2,
+		timestamp: time.Date(2016, time.October, 11, 22, 14, 15, 0, time.UTC),
+		hostname:  "mymachine",
+		appName:   "su",
+		procID:    "230",
+		message:   "'su root' failed for lonvick on /dev/pts/8",
+	}, m)
+
+	m, err = parseSyslog("<6>Aug  5 09:01:02 kernel: eth0 link up", time.UTC, syslogNow)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2017, time.August, 5, 9, 1, 2, 0, time.UTC), m.timestamp)
+	assert.Equal(t, "", m.hostname)
+	assert.Equal(t, "kernel", m.appName)
+	assert.Equal(t, "eth0 link up", m.message)
+
+	m, err = parseSyslog("<14>no header at all", time.UTC, syslogNow)
+	assert.NoError(t, err)
+	assert.True(t, m.timestamp.IsZero())
+	assert.Equal(t, "no header at all", m.message)
+}
+
+func Test_ParseSyslog_Invalid(t *testing.T) {
+	for _, msg := range []string{"no priority", "<>1 -", "<192>Aug  5 09:01:02 host app: msg", "<13>1 2017-08-18 host", "<13>1 - host app - - [unterminated"} {
+		_, err := parseSyslog(msg, time.UTC, syslogNow)
+		assert.Error(t, err, msg)
+	}
+}
+
+func Test_NewSyslogEvent(t *testing.T) {
+	defer func(sourcetype string) { syslogSourcetype = sourcetype }(syslogSourcetype)
+	syslogSourcetype = "syslog"
+
+	msg := "<163>1 2017-08-18T14:37:15.123Z switch2 lldpd - - - neighbour lost"
+	e, ok := newSyslogEvent(msg, time.UTC, syslogNow)
+	assert.True(t, ok)
+	assert.Equal(t, hecEvent{
+		Event:      msg,
+		Time:       1503067035.123,
+		Host:       "switch2",
+		Source:     "lldpd",
+		Sourcetype: "syslog",
+		Fields:     map[string]interface{}{"facility": "local4", "severity": "err"},
+	}, e.hec())
+	severity, _ := e.Field("severity")
+	assert.Equal(t, "err", severity)
+
+	e, ok = newSyslogEvent("garbage", time.UTC, syslogNow)
+	assert.False(t, ok)
+	assert.Equal(t, "garbage", e.hec().Event)
+	assert.Equal(t, syslogNow, e.time)
+}
+
+func Test_ReadSyslogFrame(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader("<13>Aug  5 09:01:02 host app: newline framed\n" +
+		"28 <13>1 - - - - - - multi\nline" +
+		"<14>last without newline"))
+	frames := []string{}
+	for {
+		frame, _, err := readSyslogFrame(r)
+		if frame != "" {
+			frames = append(frames, frame)
+		}
+		if err != nil {
+			break
+		}
+	}
+	assert.Equal(t, []string{"<13>Aug  5 09:01:02 host app: newline framed", "<13>1 - - - - - - multi\nline", "<14>last without newline"}, frames)
+
+	_, _, err := readSyslogFrame(bufio.NewReader(strings.NewReader("99999999 <13>too long")))
+	assert.Error(t, err, "the frame ends before its length")
+	_, _, err = readSyslogFrame(bufio.NewReader(strings.NewReader("99999999999 <13>overflow")))
+	assert.Error(t, err)
+
+	defer withFraming(framingNewline, 8)()
+	r = bufio.NewReader(strings.NewReader("<13>long message\r\n<13>ok\n"))
+	frame, truncated, err := readSyslogFrame(r)
+	assert.NoError(t, err)
+	assert.Equal(t, "<13>long", frame)
+	assert.True(t, truncated)
+	frame, truncated, err = readSyslogFrame(r)
+	assert.Equal(t, "<13>ok", frame)
+	assert.False(t, truncated)
+
+	r = bufio.NewReader(strings.NewReader("22 <13>1 - - - - - - long6 <13>ok"))
+	frame, truncated, err = readSyslogFrame(r)
+	assert.NoError(t, err)
+	assert.Equal(t, "<13>1 - ", frame)
+	assert.True(t, truncated, "octet counted frames are truncated rather than closing the connection")
+	frame, truncated, err = readSyslogFrame(r)
+	assert.NoError(t, err)
+	assert.Equal(t, "<13>ok", frame)
+	assert.False(t, truncated)
+}
+
+func Test_SyslogInput_ReceivesUDPAndTCP(t *testing.T) {
+	s, err := newSyslogInput("127.0.0.1:0", "127.0.0.1:0")
+	assert.NoError(t, err)
+	events := make(chan *logEvent)
+	go s.run(events)
+	defer s.close()
+
+	udp, err := net.Dial("udp", s.udp.LocalAddr().String())
+	assert.NoError(t, err)
+	defer udp.Close()
+	udp.Write([]byte("<13>1 - udphost app - - - over udp\n"))
+	assert.Equal(t, "udphost", receive(t, events).host)
+
+	tcp, err := net.Dial("tcp", s.tcp.Addr().String())
+	assert.NoError(t, err)
+	defer tcp.Close()
+	tcp.Write([]byte("30 <13>1 - tcphost app - - - one\n<13>1 - tcphost app - - - two\n"))
+	assert.Equal(t, "one", receive(t, events).fields["message"])
+	assert.Equal(t, "two", receive(t, events).fields["message"])
+}
+
+func receive(t *testing.T, events <-chan *logEvent) *logEvent {
+	select {
+	case e := <-events:
+		return e
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event received")
+		return nil
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+var (
+	timestampFile string
+	timezone      string
+	// defaultTimestampRules find RFC3339 timestamps anywhere in the event, then Apache access log timestamps
+	defaultTimestampRules = []timestampRule{
+		{Regex: "([0-9]+)-(0[1-9]|1[012])-(0[1-9]|[12][0-9]|3[01])[Tt]([01][0-9]|2[0-3]):([0-5][0-9]):([0-5][0-9]|60)(.[0-9]+)?(([Zz])|([+|-]([01][0-9]|2[0-3]):[0-5][0-9]))", Layout: time.RFC3339Nano},
+		{Regex: `\[([0-9]{2}/[A-Za-z]{3}/[0-9]{4}:[0-9]{2}:[0-9]{2}:[0-9]{2} [+-][0-9]{4})\]`, Group: 1, Layout: "02/Jan/2006:15:04:05 -0700"},
+	}
+	timestampExtractors, _ = newTimestampExtractors(defaultTimestampRules, time.UTC)
+	epochUnits             = map[string]time.Duration{"s": time.Second, "ms": time.Millisecond, "us": time.Microsecond}
+)
+
+// timestampRule describes where to find the event time: either a named field or a regex match on the raw event.
+// The value is parsed with a Go time layout, or as epoch time in seconds (s), milliseconds (ms) or microseconds (us).
+type timestampRule struct {
+	Field  string `json:"field,omitempty"`
+	Regex  string `json:"regex,omitempty"`
+	Group  int    `json:"group,omitempty"`
+	Layout string `json:"layout,omitempty"`
+	Epoch  string `json:"epoch,omitempty"`
+}
+
+type timestampExtractor struct {
+	timestampRule
+	regex    *regexp.Regexp
+	location *time.Location
+}
+
+func loadTimestampRules(path string) ([]timestampRule, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	rules := []timestampRule{}
+	if err := json.NewDecoder(f).Decode(&rules); err != nil {
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+	return rules, nil
+}
+
+func setupTimestampExtractors() error {
+	location, err := time.LoadLocation(timezone)
+	if err != nil {
+		return err
+	}
+	rules := defaultTimestampRules
+	if timestampFile != "" {
+		rules, err = loadTimestampRules(timestampFile)
+		if err != nil {
+			return err
+		}
+	}
+	timestampExtractors, err = newTimestampExtractors(rules, location)
+	return err
+}
+
+func newTimestampExtractors(rules []timestampRule, location *time.Location) ([]*timestampExtractor, error) {
+	extractors := []*timestampExtractor{}
+	for i, rule := range rules {
+		x := &timestampExtractor{timestampRule: rule, location: location}
+		if (rule.Field == "") == (rule.Regex == "") {
+			return nil, fmt.Errorf("timestamp rule %v: exactly one of field or regex is required", i)
+		}
+		if (rule.Layout == "") == (rule.Epoch == "") {
+			return nil, fmt.Errorf("timestamp rule %v: exactly one of layout or epoch is required", i)
+		}
+		if _, found := epochUnits[rule.Epoch]; rule.Epoch != "" && !found {
+			return nil, fmt.Errorf("timestamp rule %v: unknown epoch unit %q, expected s, ms or us", i, rule.Epoch)
+		}
+		if rule.Regex != "" {
+			re, err := regexp.Compile(rule.Regex)
+			if err != nil {
+				return nil, fmt.Errorf("timestamp rule %v: %v", i, err)
+			}
+			if rule.Group < 0 || rule.Group > re.NumSubexp() {
+				return nil, fmt.Errorf("timestamp rule %v: regex has no group %v", i, rule.Group)
+			}
+			x.regex = re
+		}
+		extractors = append(extractors, x)
+	}
+	return extractors, nil
+}
+
+func (x *timestampExtractor) extract(e *logEvent) (time.Time, bool) {
+	var value string
+	if x.regex != nil {
+		match := x.regex.FindStringSubmatch(e.raw)
+		if match == nil {
+			return time.Time{}, false
+		}
+		value = match[x.Group]
+	} else {
+		v, found := e.Field(x.Field)
+		if !found {
+			return time.Time{}, false
+		}
+		value = v
+	}
+
+	if x.Epoch != "" {
+		t, err := parseEpoch(value, epochUnits[x.Epoch])
+		return t, err == nil
+	}
+	t, err := time.ParseInLocation(x.Layout, value, x.location)
+	return t, err == nil
+}
+
+func parseEpoch(s string, unit time.Duration) (time.Time, error) {
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(0, n*int64(unit)), nil
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	sec, frac := math.Modf(f * float64(unit) / float64(time.Second))
+	return time.Unix(int64(sec), int64(frac*float64(time.Second))), nil
+}
+
+// extractTimestamp tries the configured extractors in order and falls back to the current time
+func extractTimestamp(e *logEvent) time.Time {
+	for _, x := range timestampExtractors {
+		if t, found := x.extract(e); found {
+			if x.Epoch == "us" {
+				e.precision = time.Microsecond
+			}
+			return t
+		}
+	}
+	metrics.GetOrRegisterCounter("timestamp.fallback", metrics.DefaultRegistry).Inc(1)
+	return time.Now()
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ExtractTimestamp_Defaults(t *testing.T) {
+	e := &logEvent{raw: `127.0.0.1 - - [21/Apr/2015:12:15:34 +0000] "GET /eom-file/all/e09b49d6-e1fa-11e4-bb7f-00144feab7de HTTP/1.1" 200 53706 919 919`}
+	assert.Equal(t, time.Date(2015, time.April, 21, 12, 15, 34, 0, time.UTC).Unix(), extractTimestamp(e).Unix())
+
+	e = &logEvent{raw: `{"@time":"2017-08-18T14:37:15.639583741Z"}`}
+	assert.Equal(t, time.Date(2017, time.August, 18, 14, 37, 15, 639583741, time.UTC).UnixNano(), extractTimestamp(e).UnixNano())
+}
+
+func Test_ExtractTimestamp_Rules(t *testing.T) {
+	defer func(extractors []*timestampExtractor) { timestampExtractors = extractors }(timestampExtractors)
+
+	rules, err := loadTimestampRules("testdata/timestamps.json")
+	assert.NoError(t, err)
+	london, _ := time.LoadLocation("Europe/London")
+	timestampExtractors, err = newTimestampExtractors(rules, london)
+	assert.NoError(t, err)
+
+	e := &logEvent{raw: `{"@time":"2017-08-18 14:37:15","ts":1503067035639}`}
+	assert.Equal(t, time.Date(2017, time.August, 18, 13, 37, 15, 0, time.UTC).Unix(), extractTimestamp(e).Unix())
+
+	e = &logEvent{raw: `{"ts":1503067035639}`}
+	assert.Equal(t, int64(1503067035639), extractTimestamp(e).UnixNano()/int64(time.Millisecond))
+
+	e = &logEvent{raw: `service started at 1503067035.5`}
+	assert.Equal(t, int64(1503067035500), extractTimestamp(e).UnixNano()/int64(time.Millisecond))
+}
+
+func Test_ExtractTimestamp_EpochMicros(t *testing.T) {
+	defer func(extractors []*timestampExtractor) { timestampExtractors = extractors }(timestampExtractors)
+	timestampExtractors, _ = newTimestampExtractors([]timestampRule{{Field: "__REALTIME_TIMESTAMP", Epoch: "us"}}, time.UTC)
+
+	e := &logEvent{raw: `{"__REALTIME_TIMESTAMP":"1503067035639583"}`}
+	e.time = extractTimestamp(e)
+	assert.Equal(t, 1503067035.639583, e.hec().Time)
+}
+
+func Test_ExtractTimestamp_CountsFallback(t *testing.T) {
+	fallback := metrics.GetOrRegisterCounter("timestamp.fallback", metrics.DefaultRegistry)
+	before := fallback.Count()
+
+	now := time.Now()
+	ts := extractTimestamp(&logEvent{raw: "no timestamp here"})
+	assert.False(t, ts.Before(now))
+	assert.Equal(t, before+1, fallback.Count())
+}
+
+func Test_NewTimestampExtractors_Invalid(t *testing.T) {
+	for _, rule := range []timestampRule{
+		{Layout: time.RFC3339},
+		{Field: "ts", Regex: "ts", Layout: time.RFC3339},
+		{Field: "ts"},
+		{Field: "ts", Epoch: "ns"},
+		{Regex: "(", Layout: time.RFC3339},
+		{Regex: "ts", Group: 1, Layout: time.RFC3339},
+	} {
+		_, err := newTimestampExtractors([]timestampRule{rule}, time.UTC)
+		assert.Error(t, err, "%+v", rule)
+	}
+}